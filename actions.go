@@ -0,0 +1,133 @@
+package traefik_plugin
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Block actions available to Config.BlockActions.
+const (
+	actionLog          = "log"
+	actionHeaders      = "headers"
+	actionWebhook      = "webhook"
+	actionClearCookies = "clearCookies"
+	actionRespond      = "respond"
+)
+
+// blockAction is a step applied to a blocked request, in the order of
+// BlockActions.
+type blockAction func(rw http.ResponseWriter, req *http.Request, clientIP string, b ban, now time.Time)
+
+// validateBlockActions checks the BlockActions configuration: known actions,
+// each at most once, ending with respond, and with the settings the webhook
+// and clearCookies actions need.
+func validateBlockActions(c *Config) error {
+	seen := make(map[string]struct{}, len(c.BlockActions))
+	for i, name := range c.BlockActions {
+		switch name {
+		case actionLog, actionHeaders, actionRespond:
+		case actionWebhook:
+			if c.WebhookURL == "" {
+				return fmt.Errorf("blockActions[%d]: webhook requires webhookURL", i)
+			}
+		case actionClearCookies:
+			if len(c.ClearCookiesOnBlock) == 0 {
+				return fmt.Errorf("blockActions[%d]: clearCookies requires clearCookiesOnBlock", i)
+			}
+		default:
+			return fmt.Errorf("blockActions[%d]: unknown action %q", i, name)
+		}
+
+		if _, ok := seen[name]; ok {
+			return fmt.Errorf("blockActions[%d]: duplicate action %q", i, name)
+		}
+		seen[name] = struct{}{}
+	}
+
+	if n := len(c.BlockActions); n > 0 && c.BlockActions[n-1] != actionRespond {
+		return fmt.Errorf("blockActions must end with %q", actionRespond)
+	}
+
+	return nil
+}
+
+// defaultBlockActions returns the block actions used when BlockActions is
+// empty, following the older settings: log with Verbose, headers with
+// DebugHeaders, clearCookies with ClearCookiesOnBlock, then respond.
+func defaultBlockActions(c *Config) []string {
+	var names []string
+	if c.Verbose {
+		names = append(names, actionLog)
+	}
+	if c.DebugHeaders {
+		names = append(names, actionHeaders)
+	}
+	if len(c.ClearCookiesOnBlock) > 0 {
+		names = append(names, actionClearCookies)
+	}
+
+	return append(names, actionRespond)
+}
+
+// newBlockActions returns the block actions named in names, which must have
+// passed validateBlockActions.
+func (m *Fail2BanMiddleware) newBlockActions(names []string) []blockAction {
+	actions := make([]blockAction, 0, len(names))
+	for _, name := range names {
+		switch name {
+		case actionLog:
+			actions = append(actions, m.logBlock)
+		case actionHeaders:
+			actions = append(actions, m.setDebugHeaders)
+		case actionWebhook:
+			actions = append(actions, m.notifyBlock)
+		case actionClearCookies:
+			actions = append(actions, m.expireCookies)
+		case actionRespond:
+			actions = append(actions, m.respond)
+		}
+	}
+
+	return actions
+}
+
+// block applies the block actions to a request from clientIP blocked by b.
+func (m *Fail2BanMiddleware) block(rw http.ResponseWriter, req *http.Request, clientIP string, b ban, now time.Time) {
+	m.recordBlock(req, clientIP, b, now)
+	m.logBlocked(rw, req, clientIP, now, func(rw http.ResponseWriter) {
+		for _, action := range m.blockActions {
+			action(rw, req, clientIP, b, now)
+		}
+	})
+}
+
+// logBlock is the log block action.
+func (m *Fail2BanMiddleware) logBlock(_ http.ResponseWriter, req *http.Request, clientIP string, b ban, _ time.Time) {
+	attrs := []any{"ip", m.logIP(clientIP), "rule", b.rule}
+	if b.cidr != nil {
+		attrs = append(attrs, "cidr", b.cidr.String())
+	}
+	attrs = append(attrs, "reason", b.reason, "source", b.source, "status", m.banStatus(b), "path", req.URL.Path)
+	m.logger.Info("Blocked request", attrs...)
+}
+
+// setDebugHeaders is the headers block action: it adds the matched rule,
+// reason and blocklist range to the response.
+func (m *Fail2BanMiddleware) setDebugHeaders(rw http.ResponseWriter, _ *http.Request, _ string, b ban, _ time.Time) {
+	rw.Header().Set("X-Fail2Ban-Rule", b.rule)
+	if b.reason != "" {
+		rw.Header().Set("X-Fail2Ban-Reason", b.reason)
+	}
+	if b.cidr != nil {
+		rw.Header().Set("X-Fail2Ban-CIDR", b.cidr.String())
+	}
+}
+
+// expireCookies is the clearCookies block action: it expires the cookies in
+// ClearCookiesOnBlock.
+func (m *Fail2BanMiddleware) expireCookies(rw http.ResponseWriter, _ *http.Request, _ string, _ ban, _ time.Time) {
+	for _, name := range m.clearCookies {
+		http.SetCookie(rw, &http.Cookie{Name: name, Path: "/", MaxAge: -1, Expires: time.Unix(0, 0)})
+	}
+}
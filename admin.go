@@ -0,0 +1,361 @@
+package traefik_plugin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+// maxAdminBodyBytes caps the size of admin request bodies.
+const maxAdminBodyBytes = 1 << 20
+
+// maxBanNoteBytes caps the length of the note of a manual ban.
+const maxBanNoteBytes = 1024
+
+// banRequest is the body of POST /ban, POST /unban and POST /allow.
+type banRequest struct {
+	IP string `json:"ip"`
+	// Duration is a Go duration such as "1h"; empty bans until unbanned, or
+	// for POST /unban lifts the blocklist entries while they stay listed.
+	Duration string `json:"duration,omitempty"`
+	// Soft redirects the IP to the challenge page instead of blocking it.
+	Soft bool `json:"soft,omitempty"`
+	// Note is free text kept with the ban, such as who banned the IP and
+	// why.
+	Note string `json:"note,omitempty"`
+}
+
+// banEntry describes a dynamic ban in admin responses and the state file.
+type banEntry struct {
+	IP        string     `json:"ip"`
+	Rule      string     `json:"rule"`
+	Reason    string     `json:"reason,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Soft      bool       `json:"soft,omitempty"`
+	Note      string     `json:"note,omitempty"`
+	// HitCount and LastSeen tell how many requests the ban has blocked and
+	// when the latest arrived.
+	HitCount uint64     `json:"hitCount,omitempty"`
+	LastSeen *time.Time `json:"lastSeen,omitempty"`
+}
+
+// startAdmin starts the admin API on addr, reachable from the allowed ranges
+// with up to rateLimit requests per source and minute. It listens
+// synchronously so a bad address fails New, and shuts the server down when
+// m.ctx is cancelled.
+func (m *Fail2BanMiddleware) startAdmin(addr, token string, allowed []*net.IPNet, rateLimit int) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	// Everything but the health check requires the token, so orchestrators
+	// can probe readiness without it.
+	mux := http.NewServeMux()
+	mux.Handle("/ban", requireToken(token, http.HandlerFunc(m.handleBan)))
+	mux.Handle("/unban", requireToken(token, http.HandlerFunc(m.handleUnban)))
+	mux.Handle("/bans", requireToken(token, http.HandlerFunc(m.handleBans)))
+	mux.Handle("/allow", requireToken(token, http.HandlerFunc(m.handleAllow)))
+	mux.Handle("/allows", requireToken(token, http.HandlerFunc(m.handleAllows)))
+	mux.Handle("/events", requireToken(token, http.HandlerFunc(m.handleEvents)))
+	mux.Handle("/export", requireToken(token, http.HandlerFunc(m.handleExport)))
+	mux.Handle("/stats", requireToken(token, http.HandlerFunc(m.handleStats)))
+	mux.Handle("/lockdown", requireToken(token, http.HandlerFunc(m.handleLockdown)))
+	mux.Handle("/pause", requireToken(token, http.HandlerFunc(m.handlePause)))
+	mux.Handle("/check", requireToken(token, http.HandlerFunc(m.handleCheck)))
+	mux.Handle("/reload", requireToken(token, http.HandlerFunc(m.handleReload)))
+	mux.Handle("/reload-config", requireToken(token, http.HandlerFunc(m.handleReloadConfig)))
+	mux.Handle("/import-fail2ban", requireToken(token, http.HandlerFunc(m.handleImportFail2Ban)))
+	mux.HandleFunc("/health", m.handleHealth)
+
+	guard := &adminGuard{next: mux, allowed: allowed, limit: rateLimit, now: m.nowFunc, windows: make(map[string]*adminWindow)}
+	server := &http.Server{
+		// A method value, as Yaegi can't hand an interpreted type to
+		// net/http as a Handler.
+		Handler:           http.HandlerFunc(guard.ServeHTTP),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	m.wg.Add(2)
+	go func() {
+		defer m.wg.Done()
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			m.logger.Error("Admin API stopped", "error", err)
+		}
+	}()
+	go func() {
+		defer m.wg.Done()
+		<-m.ctx.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+	}()
+
+	m.logger.Info("Admin API listening", "addr", listener.Addr().String())
+
+	return nil
+}
+
+// requireToken rejects requests that don't carry "Authorization: Bearer
+// <token>". The comparison is constant-time.
+func requireToken(token string, next http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), expected) != 1 {
+			rw.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(rw, http.StatusUnauthorized, "invalid or missing token")
+			return
+		}
+
+		next.ServeHTTP(rw, req)
+	})
+}
+
+// handleBan bans an IP, optionally for a limited duration.
+func (m *Fail2BanMiddleware) handleBan(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeMethodNotAllowed(rw, http.MethodPost)
+		return
+	}
+
+	body, ip, err := decodeBanRequest(rw, req)
+	if err != nil {
+		writeJSONError(rw, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if body.Soft && m.challengeURL == nil {
+		writeJSONError(rw, http.StatusBadRequest, "soft bans require a challengeURL")
+		return
+	}
+	if len(body.Note) > maxBanNoteBytes {
+		writeJSONError(rw, http.StatusBadRequest, fmt.Sprintf("note exceeds %d bytes", maxBanNoteBytes))
+		return
+	}
+
+	now := m.nowFunc()
+	b := ban{rule: ruleManual, soft: body.Soft, note: body.Note, hits: &banHits{}}
+	if body.Duration != "" {
+		d, err := time.ParseDuration(body.Duration)
+		if err != nil || d <= 0 {
+			writeJSONError(rw, http.StatusBadRequest, fmt.Sprintf("invalid duration %q", body.Duration))
+			return
+		}
+		b.expiry = now.Add(d)
+	}
+
+	m.mu.Lock()
+	m.bans.Set(ip, b)
+	delete(m.unbanned, ip)
+	m.forgetClient(ip)
+	m.mu.Unlock()
+	m.cache.invalidate(ip)
+	m.shareBan(ip, b)
+	m.notifyBan(ip, b, now, "")
+
+	m.logger.Info("Banned IP via admin API", "ip", m.logIP(ip), "duration", body.Duration, "note", sanitizeNote(body.Note))
+	writeJSON(rw, http.StatusOK, newBanEntry(ip, b))
+}
+
+// unban is the lift of the blocklist entries listing an IP through the admin
+// API.
+type unban struct {
+	// entries maps the lifted entries, the IP itself or CIDRs containing
+	// it, to the file or URL each was listed by.
+	entries map[string]string
+	expiry  time.Time // zero lifts the entries for as long as they're listed
+}
+
+// active reports whether the lift is still in effect at now.
+func (u unban) active(now time.Time) bool {
+	return u.expiry.IsZero() || now.Before(u.expiry)
+}
+
+// liftedEntries returns the blocklist entries lifted for clientIP at now, nil
+// if there are none. The map must not be modified.
+func (m *Fail2BanMiddleware) liftedEntries(clientIP string, now time.Time) map[string]string {
+	m.mu.RLock()
+	u, ok := m.unbanned[clientIP]
+	m.mu.RUnlock()
+
+	if !ok || !u.active(now) {
+		return nil
+	}
+	return u.entries
+}
+
+// dropExpiredUnbans removes the lifts that have run out by now. The caller
+// must hold m.mu for writing.
+func (m *Fail2BanMiddleware) dropExpiredUnbans(now time.Time) {
+	for ip, u := range m.unbanned {
+		if !u.active(now) {
+			delete(m.unbanned, ip)
+		}
+	}
+}
+
+// handleUnban lifts the dynamic ban on an IP, for example once it has passed
+// the challenge page, along with the ban on its range with IPv4BanPrefix or
+// IPv6BanPrefix. The blocklist entries listing the IP, the IP itself and the
+// CIDRs containing it, are lifted for it too, for the duration in the request
+// body when one is given: a lifted entry stays lifted across blocklist
+// reloads for as long as the same source lists it. Entries listed later, and
+// the other rules, still apply.
+func (m *Fail2BanMiddleware) handleUnban(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeMethodNotAllowed(rw, http.MethodPost)
+		return
+	}
+
+	body, ip, err := decodeBanRequest(rw, req)
+	if err != nil {
+		writeJSONError(rw, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	now := m.nowFunc()
+	u := unban{entries: m.currentBlocklist().entriesOf(ip)}
+	if body.Duration != "" {
+		d, err := time.ParseDuration(body.Duration)
+		if err != nil || d <= 0 {
+			writeJSONError(rw, http.StatusBadRequest, fmt.Sprintf("invalid duration %q", body.Duration))
+			return
+		}
+		u.expiry = now.Add(d)
+	}
+
+	keys := m.banKeys(ip)
+	m.mu.Lock()
+	for _, key := range keys {
+		m.bans.Delete(key)
+	}
+	m.forgetClient(ip)
+	m.dropExpiredUnbans(now)
+	if len(u.entries) > 0 {
+		m.unbanned[ip] = u
+	} else {
+		delete(m.unbanned, ip)
+	}
+	m.mu.Unlock()
+	if len(keys) > 1 {
+		m.cache.purge()
+	} else {
+		m.cache.invalidate(ip)
+	}
+	for _, key := range keys {
+		m.unshareBan(key)
+	}
+
+	m.logger.Info("Unbanned IP via admin API", "ip", m.logIP(ip))
+	rw.WriteHeader(http.StatusNoContent)
+}
+
+// handleBans lists the dynamic bans that are in effect, with how many requests
+// each has blocked and when the latest arrived.
+func (m *Fail2BanMiddleware) handleBans(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeMethodNotAllowed(rw, http.MethodGet)
+		return
+	}
+
+	now := m.nowFunc()
+	entries := []banEntry{}
+
+	m.mu.RLock()
+	for ip, b := range m.bans.List() {
+		if b.expiry.IsZero() || now.Before(b.expiry) {
+			entries = append(entries, newBanEntry(ip, b))
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].IP < entries[j].IP })
+	writeJSON(rw, http.StatusOK, entries)
+}
+
+// decodeBanRequest decodes a ban request body and returns it along with the
+// canonical form of its IP.
+func decodeBanRequest(rw http.ResponseWriter, req *http.Request) (banRequest, string, error) {
+	var body banRequest
+	if err := json.NewDecoder(http.MaxBytesReader(rw, req.Body, maxAdminBodyBytes)).Decode(&body); err != nil {
+		return body, "", fmt.Errorf("invalid request body: %w", err)
+	}
+
+	ip := net.ParseIP(body.IP)
+	if ip == nil {
+		return body, "", fmt.Errorf("invalid ip %q", body.IP)
+	}
+
+	return body, ip.String(), nil
+}
+
+// newBanEntry returns the admin representation of the ban on ip.
+func newBanEntry(ip string, b ban) banEntry {
+	entry := banEntry{IP: ip, Rule: b.rule, Reason: b.reason, Soft: b.soft, Note: b.note}
+	if !b.expiry.IsZero() {
+		expiry := b.expiry.UTC()
+		entry.ExpiresAt = &expiry
+	}
+	if b.hits != nil {
+		entry.HitCount = atomic.LoadUint64(&b.hits.count)
+		if lastSeen := atomic.LoadInt64(&b.hits.lastSeen); lastSeen != 0 {
+			t := time.Unix(0, lastSeen).UTC()
+			entry.LastSeen = &t
+		}
+	}
+
+	return entry
+}
+
+// toBan converts an admin or state file entry back into a ban.
+func (e banEntry) toBan() ban {
+	b := ban{rule: e.Rule, reason: e.Reason, soft: e.Soft, note: e.Note, hits: &banHits{count: e.HitCount}}
+	if e.ExpiresAt != nil {
+		b.expiry = *e.ExpiresAt
+	}
+	if e.LastSeen != nil {
+		b.hits.lastSeen = e.LastSeen.UnixNano()
+	}
+
+	return b
+}
+
+// writeJSON writes v as a JSON response with the given status.
+func writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Content-Type", contentTypeJSON)
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(v)
+}
+
+// writeJSONError writes a JSON error response.
+func writeJSONError(rw http.ResponseWriter, status int, message string) {
+	writeJSON(rw, status, map[string]string{"error": message})
+}
+
+// writeMethodNotAllowed rejects a request made with the wrong method.
+func writeMethodNotAllowed(rw http.ResponseWriter, allowed string) {
+	rw.Header().Set("Allow", allowed)
+	writeJSONError(rw, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+// sanitizeNote replaces the control characters of a ban note with spaces, so
+// a note can't forge log lines.
+func sanitizeNote(note string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, note)
+}
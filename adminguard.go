@@ -0,0 +1,111 @@
+package traefik_plugin
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// adminRateWindow is the window AdminRateLimit and adminMaxAuthFailures
+	// count over.
+	adminRateWindow = time.Minute
+
+	// adminMaxAuthFailures is how many requests with a wrong token a source
+	// may make per adminRateWindow before it is refused outright, so the
+	// token can't be brute-forced.
+	adminMaxAuthFailures = 10
+)
+
+// adminGuard sits in front of the admin API: it rejects sources outside
+// AdminAllowedIPs and limits the requests and failed authentications of each
+// source per adminRateWindow.
+type adminGuard struct {
+	next    http.Handler
+	allowed []*net.IPNet // empty allows every source
+	limit   int          // requests per window, 0 for no limit
+	now     func() time.Time
+
+	mu        sync.Mutex
+	windows   map[string]*adminWindow // by source IP
+	lastSweep time.Time
+}
+
+// adminWindow counts the requests of one source in the current window.
+type adminWindow struct {
+	start    time.Time
+	requests int
+	failures int
+}
+
+func (g *adminGuard) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	source := normalizeIP(hostFromAddr(req.RemoteAddr))
+	if len(g.allowed) > 0 {
+		if ip := net.ParseIP(source); ip == nil || !containsIP(g.allowed, ip) {
+			writeJSONError(rw, http.StatusForbidden, "source not allowed")
+			return
+		}
+	}
+
+	// The health check is probed often and needs no token, so it isn't
+	// limited.
+	if req.URL.Path == "/health" {
+		g.next.ServeHTTP(rw, req)
+		return
+	}
+
+	if retryAfter, ok := g.take(source); !ok {
+		rw.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds()+0.5)))
+		writeJSONError(rw, http.StatusTooManyRequests, "too many requests")
+		return
+	}
+
+	capture := &responseRecorder{ResponseWriter: rw}
+	g.next.ServeHTTP(capture, req)
+	if capture.status == http.StatusUnauthorized {
+		g.fail(source)
+	}
+}
+
+// take counts a request of source, reporting false and how long until the
+// window ends if source is over the request limit or has failed to
+// authenticate too often.
+func (g *adminGuard) take(source string) (time.Duration, bool) {
+	now := g.now()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if now.Sub(g.lastSweep) >= adminRateWindow {
+		for key, w := range g.windows {
+			if now.Sub(w.start) >= adminRateWindow {
+				delete(g.windows, key)
+			}
+		}
+		g.lastSweep = now
+	}
+
+	w, ok := g.windows[source]
+	if !ok || now.Sub(w.start) >= adminRateWindow {
+		w = &adminWindow{start: now}
+		g.windows[source] = w
+	}
+	if w.failures >= adminMaxAuthFailures || (g.limit > 0 && w.requests >= g.limit) {
+		return w.start.Add(adminRateWindow).Sub(now), false
+	}
+	w.requests++
+
+	return 0, true
+}
+
+// fail counts a failed authentication of source.
+func (g *adminGuard) fail(source string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if w, ok := g.windows[source]; ok {
+		w.failures++
+	}
+}
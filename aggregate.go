@@ -0,0 +1,122 @@
+package traefik_plugin
+
+import "net"
+
+// aggregate replaces the list's plain entries, IPs and CIDRs without a reason,
+// expiry or hostname, by the minimal set of CIDRs covering them. Exact IPs an
+// exclusion applies to are kept as they are, since exclusions only carve into
+// CIDRs. It returns the number of entries before and after and must be called
+// before index.
+func (l *ipList) aggregate() (before, after int) {
+	before = len(l.ips) + len(l.nets)
+
+	var excluded *cidrTrie
+	if l.excluded != nil {
+		excluded = newCIDRTrie(l.excluded.nets)
+	}
+
+	var plain []*net.IPNet
+	for entry := range l.ips {
+		ip := net.ParseIP(entry)
+		if l.annotated(entry) || ip == nil || (excluded != nil && l.excludesExact(entry, ip, excluded)) {
+			continue
+		}
+
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+			bits = 8 * net.IPv4len
+		}
+		plain = append(plain, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		delete(l.ips, entry)
+		delete(l.sources, entry)
+	}
+
+	kept := make([]*net.IPNet, 0, len(l.nets))
+	for _, ipNet := range l.nets {
+		key := ipNet.String()
+		if l.annotated(key) {
+			kept = append(kept, ipNet)
+			continue
+		}
+		plain = append(plain, ipNet)
+		delete(l.sources, key)
+	}
+	aggregated := aggregateNets(plain)
+
+	// Kept entries stay in place, followed by the aggregated ranges.
+	keptNets := make(map[string]struct{}, len(kept))
+	for _, ipNet := range kept {
+		keptNets[ipNet.String()] = struct{}{}
+	}
+	order := make([]string, 0, len(l.ips)+len(kept)+len(aggregated))
+	for _, entry := range l.order {
+		_, isIP := l.ips[entry]
+		_, isNet := keptNets[entry]
+		if isIP || isNet {
+			order = append(order, entry)
+		}
+	}
+	for _, ipNet := range aggregated {
+		order = append(order, ipNet.String())
+	}
+
+	l.nets = append(kept, aggregated...)
+	l.order = order
+
+	return before, len(l.ips) + len(l.nets)
+}
+
+// annotated reports whether entry carries a reason, an expiry or a hostname,
+// which aggregation would lose.
+func (l *ipList) annotated(entry string) bool {
+	if _, ok := l.reasons[entry]; ok {
+		return true
+	}
+	if _, ok := l.expiries[entry]; ok {
+		return true
+	}
+	_, ok := l.hosts[entry]
+	return ok
+}
+
+// excludesExact reports whether an exclusion of the list applies to the exact
+// entry at ip, given the trie of the exclusion CIDRs.
+func (l *ipList) excludesExact(entry string, ip net.IP, excluded *cidrTrie) bool {
+	if _, ok := l.excluded.ips[entry]; ok {
+		return true
+	}
+	return excluded.lookup(ip) != nil
+}
+
+// subtractNets returns the minimal CIDRs covering ipNet minus the excluded
+// ranges, by halving ipNet until no half partly overlaps an exclusion.
+func subtractNets(ipNet *net.IPNet, excluded []*net.IPNet) []*net.IPNet {
+	ones, bits := ipNet.Mask.Size()
+
+	var overlapping []*net.IPNet
+	for _, ex := range excluded {
+		exOnes, exBits := ex.Mask.Size()
+		if exBits != bits {
+			continue
+		}
+		if ex.Contains(ipNet.IP) && exOnes <= ones {
+			return nil
+		}
+		if ipNet.Contains(ex.IP) {
+			overlapping = append(overlapping, ex)
+		}
+	}
+	if len(overlapping) == 0 {
+		return []*net.IPNet{ipNet}
+	}
+
+	mask := net.CIDRMask(ones+1, bits)
+	lower := make(net.IP, len(ipNet.IP))
+	copy(lower, ipNet.IP)
+	upper := make(net.IP, len(ipNet.IP))
+	copy(upper, ipNet.IP)
+	upper[ones/8] |= 0x80 >> (ones % 8)
+
+	return append(subtractNets(&net.IPNet{IP: lower, Mask: mask}, overlapping), subtractNets(&net.IPNet{IP: upper, Mask: mask}, overlapping)...)
+}
@@ -0,0 +1,112 @@
+package traefik_plugin
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// allowEntry describes a temporary allow in admin responses and the state
+// file.
+type allowEntry struct {
+	IP        string    `json:"ip"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Note      string    `json:"note,omitempty"`
+}
+
+// tempAllow is a temporary allow of an IP.
+type tempAllow struct {
+	expiry time.Time
+	note   string
+}
+
+// handleAllow lets an IP through every block, whatever Precedence says, for
+// the duration in the request body, so support can grant a short exception
+// without editing the allowlist. The entry is dropped once it expires.
+func (m *Fail2BanMiddleware) handleAllow(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeMethodNotAllowed(rw, http.MethodPost)
+		return
+	}
+
+	body, ip, err := decodeBanRequest(rw, req)
+	if err != nil {
+		writeJSONError(rw, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(body.Note) > maxBanNoteBytes {
+		writeJSONError(rw, http.StatusBadRequest, fmt.Sprintf("note exceeds %d bytes", maxBanNoteBytes))
+		return
+	}
+
+	d, err := time.ParseDuration(body.Duration)
+	if err != nil || d <= 0 {
+		writeJSONError(rw, http.StatusBadRequest, fmt.Sprintf("invalid duration %q", body.Duration))
+		return
+	}
+
+	now := m.nowFunc()
+	a := tempAllow{expiry: now.Add(d), note: body.Note}
+
+	m.mu.Lock()
+	m.dropExpiredAllows(now)
+	m.tempAllows[ip] = a
+	m.mu.Unlock()
+	m.cache.invalidate(ip)
+
+	m.logger.Info("Allowed IP temporarily via admin API", "ip", m.logIP(ip), "duration", body.Duration, "note", sanitizeNote(body.Note))
+	writeJSON(rw, http.StatusOK, newAllowEntry(ip, a))
+}
+
+// handleAllows lists the temporary allows in effect.
+func (m *Fail2BanMiddleware) handleAllows(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeMethodNotAllowed(rw, http.MethodGet)
+		return
+	}
+
+	entries := m.allowEntries(m.nowFunc())
+	sort.Slice(entries, func(i, j int) bool { return entries[i].IP < entries[j].IP })
+	writeJSON(rw, http.StatusOK, entries)
+}
+
+// allowEntries returns the temporary allows in effect at now.
+func (m *Fail2BanMiddleware) allowEntries(now time.Time) []allowEntry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entries := []allowEntry{}
+	for ip, a := range m.tempAllows {
+		if now.Before(a.expiry) {
+			entries = append(entries, newAllowEntry(ip, a))
+		}
+	}
+
+	return entries
+}
+
+// tempAllowed reports whether clientIP is temporarily allowed at now.
+func (m *Fail2BanMiddleware) tempAllowed(clientIP string, now time.Time) bool {
+	m.mu.RLock()
+	a, ok := m.tempAllows[clientIP]
+	m.mu.RUnlock()
+
+	return ok && now.Before(a.expiry)
+}
+
+// dropExpiredAllows removes the temporary allows that have run out by now.
+// The caller must hold m.mu for writing.
+func (m *Fail2BanMiddleware) dropExpiredAllows(now time.Time) {
+	for ip, a := range m.tempAllows {
+		if !now.Before(a.expiry) {
+			delete(m.tempAllows, ip)
+		}
+	}
+}
+
+// newAllowEntry returns the admin representation of the temporary allow of
+// ip.
+func newAllowEntry(ip string, a tempAllow) allowEntry {
+	return allowEntry{IP: ip, ExpiresAt: a.expiry.UTC(), Note: a.note}
+}
@@ -0,0 +1,46 @@
+package traefik_plugin
+
+import "net"
+
+// Prefix lengths kept of client IPs by AnonymizeLoggedIPs.
+const (
+	anonymizedPrefixV4 = 24
+	anonymizedPrefixV6 = 48
+)
+
+// anonymizeIP zeroes the last octet of an IPv4 address and the last 80 bits of
+// an IPv6 one. A range narrower than that, such as the key of a range ban, is
+// widened to it. Other strings are returned as is.
+func anonymizeIP(clientIP string) string {
+	if _, ipNet, err := net.ParseCIDR(clientIP); err == nil {
+		ones, bits := ipNet.Mask.Size()
+		prefix := anonymizedPrefixV6
+		if bits == 8*net.IPv4len {
+			prefix = anonymizedPrefixV4
+		}
+		if ones <= prefix {
+			return clientIP
+		}
+		return (&net.IPNet{IP: ipNet.IP.Mask(net.CIDRMask(prefix, bits)), Mask: net.CIDRMask(prefix, bits)}).String()
+	}
+
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return clientIP
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(anonymizedPrefixV4, 8*net.IPv4len)).String()
+	}
+
+	return ip.Mask(net.CIDRMask(anonymizedPrefixV6, 8*net.IPv6len)).String()
+}
+
+// logIP returns clientIP as written to the logs, the block log and the event
+// log: anonymized with AnonymizeLoggedIPs, in full otherwise.
+func (m *Fail2BanMiddleware) logIP(clientIP string) string {
+	if m.anonymizeLoggedIPs {
+		return anonymizeIP(clientIP)
+	}
+
+	return clientIP
+}
@@ -0,0 +1,77 @@
+package traefik_plugin
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestAnonymizeIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"203.0.113.7", "203.0.113.0"},
+		{"2001:db8:1:2:3:4:5:6", "2001:db8:1::"},
+		{"203.0.113.0/28", "203.0.113.0/24"},
+		{"198.51.0.0/16", "198.51.0.0/16"},
+		{"2001:db8:1:2::/64", "2001:db8:1::/48"},
+		{"garbage", "garbage"},
+	}
+	for _, tt := range tests {
+		if got := anonymizeIP(tt.ip); got != tt.want {
+			t.Errorf("anonymizeIP(%q) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+}
+
+// lockedBuffer is a bytes.Buffer safe for the logger to write from several
+// goroutines.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+// TestAnonymizedBanLog checks that the ban and admin logs only carry the
+// anonymized client IP with AnonymizeLoggedIPs.
+func TestAnonymizedBanLog(t *testing.T) {
+	m := newTestMiddleware(t, func(c *Config) {
+		c.MaxRequests = 1
+		c.AnonymizeLoggedIPs = true
+	})
+	var logs lockedBuffer
+	m.logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+	serve(m, "203.0.113.7:4321", "/fail", nil)
+	serve(m, "203.0.113.7:4321", "/fail", nil)
+	if rec := adminPost(m.handleUnban, `{"ip": "203.0.113.7"}`); rec.Code != http.StatusNoContent {
+		t.Fatalf("POST /unban: status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	got := logs.String()
+	for _, msg := range []string{`msg="Banning IP" ip=203.0.113.0 `, `msg="Unbanned IP via admin API" ip=203.0.113.0`} {
+		if !strings.Contains(got, msg) {
+			t.Errorf("logs lack %q:\n%s", msg, got)
+		}
+	}
+	if strings.Contains(got, "203.0.113.7") {
+		t.Errorf("logs carry the full client IP:\n%s", got)
+	}
+}
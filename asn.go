@@ -0,0 +1,145 @@
+package traefik_plugin
+
+import (
+	"container/list"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// asnCacheSize caps how many client IPs' ASNs are cached.
+const asnCacheSize = 10000
+
+// asnDB looks up the autonomous system of an IP.
+type asnDB interface {
+	// asn returns the number of the autonomous system announcing ip.
+	asn(ip net.IP) (uint, error)
+	Close() error
+}
+
+// openASN opens the ASN database used to block autonomous systems. Like the
+// GeoIP database, one that can't be opened is logged once and disables ASN
+// blocking instead of failing startup.
+func (m *Fail2BanMiddleware) openASN(path string, asns []int) {
+	if path == "" || len(asns) == 0 {
+		return
+	}
+
+	db, err := openASNDB(path)
+	if err != nil {
+		m.logger.Error("Error opening ASN database, ASN blocking disabled", "path", path, "error", err)
+		m.degraded = append(m.degraded, "asn")
+		return
+	}
+
+	m.asnDB = db
+	m.asnCache = newASNCache(asnCacheSize)
+	m.blockedASNs = make(map[uint]struct{}, len(asns))
+	for _, asn := range asns {
+		m.blockedASNs[uint(asn)] = struct{}{}
+	}
+}
+
+// blockedASN returns the ASN of clientIP and whether it is blocked. Lookups
+// are cached; failures count as not blocked and are cached too, as the
+// database doesn't change while it is open.
+func (m *Fail2BanMiddleware) blockedASN(clientIP string) (uint, bool) {
+	if m.asnDB == nil {
+		return 0, false
+	}
+
+	asn, ok := m.asnCache.get(clientIP)
+	if !ok {
+		if ip := net.ParseIP(clientIP); ip != nil {
+			var err error
+			asn, err = m.asnDB.asn(ip)
+			if err != nil {
+				m.logger.Debug("Failed to look up ASN of client IP", "ip", m.logIP(clientIP), "error", err)
+				asn = 0
+			}
+		}
+		m.asnCache.put(clientIP, asn)
+	}
+	if asn == 0 {
+		return 0, false
+	}
+
+	_, blocked := m.blockedASNs[asn]
+
+	return asn, blocked
+}
+
+// asnMatcher matches clients announced by a blocked autonomous system.
+type asnMatcher struct{ m *Fail2BanMiddleware }
+
+func (a asnMatcher) Match(ip net.IP, r *http.Request) (bool, string) {
+	b, ok := a.match(ip.String(), r, a.m.nowFunc())
+	return ok, b.reason
+}
+
+func (a asnMatcher) match(clientIP string, _ *http.Request, _ time.Time) (ban, bool) {
+	asn, ok := a.m.blockedASN(clientIP)
+	if !ok {
+		return ban{}, false
+	}
+
+	return ban{rule: ruleASN, reason: "AS" + strconv.FormatUint(uint64(asn), 10)}, true
+}
+
+// asnCache is a fixed-size LRU of the ASNs of client IPs, 0 for IPs whose
+// lookup failed.
+type asnCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // of *asnEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type asnEntry struct {
+	ip  string
+	asn uint
+}
+
+// newASNCache returns a cache holding up to size IPs' ASNs.
+func newASNCache(size int) *asnCache {
+	return &asnCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get returns the cached ASN of ip.
+func (c *asnCache) get(ip string) (uint, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[ip]
+	if !ok {
+		return 0, false
+	}
+	c.order.MoveToFront(elem)
+
+	return elem.Value.(*asnEntry).asn, true
+}
+
+// put caches the ASN of ip, evicting the least recently used entry when full.
+func (c *asnCache) put(ip string, asn uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[ip]; ok {
+		elem.Value.(*asnEntry).asn = asn
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	if c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*asnEntry).ip)
+	}
+	c.entries[ip] = c.order.PushFront(&asnEntry{ip: ip, asn: asn})
+}
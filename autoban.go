@@ -0,0 +1,491 @@
+package traefik_plugin
+
+import (
+	"container/list"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Values of Config.Preflight.
+const (
+	preflightCheck = "check"
+	preflightPass  = "pass"
+	preflightCount = "count"
+)
+
+// countsRequest reports whether req counts toward the ban threshold: its
+// method does, and it isn't a preflight unless Preflight is "count".
+func (m *Fail2BanMiddleware) countsRequest(req *http.Request) bool {
+	if m.preflight != preflightCount && isPreflight(req) {
+		return false
+	}
+	return m.countsMethod(req.Method)
+}
+
+// isPreflight reports whether req is a CORS preflight request.
+func isPreflight(req *http.Request) bool {
+	return req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// countsMethod reports whether requests with the given method count toward
+// the ban threshold.
+func (m *Fail2BanMiddleware) countsMethod(method string) bool {
+	if hasMethod(m.uncounted, method) {
+		return false
+	}
+	return m.methods == nil || hasMethod(m.methods, method)
+}
+
+// methodSet returns the set of the uppercased methods, or nil for none.
+func methodSet(methods []string) map[string]struct{} {
+	if len(methods) == 0 {
+		return nil
+	}
+
+	set := make(map[string]struct{}, len(methods))
+	for _, method := range methods {
+		set[strings.ToUpper(method)] = struct{}{}
+	}
+	return set
+}
+
+// hasMethod reports whether method is in set.
+func hasMethod(set map[string]struct{}, method string) bool {
+	_, ok := set[method]
+	return ok
+}
+
+// recordFailure adds a failed request from clientIP for reqPath with the given
+// score to its sliding window and bans the IP once the scores within findTime
+// add up to more than maxRequests. status is that of the response, or zero
+// for failures scored by request rules; a ban triggered by a 401 is an auth
+// ban. With trackByPath the window is that of the IP and path. The first
+// gracePeriodRequests failures of a window that starts empty are kept with a
+// score of zero, so they count for neither the ban nor its reason. With
+// DistinctPathThreshold the IP is also banned once its counted failures hit
+// too many distinct paths. A change of findTime restarts the windows.
+func (m *Fail2BanMiddleware) recordFailure(clientIP, reqPath, traceID string, status, score int) {
+	if clientIP == "" {
+		return
+	}
+
+	now := m.nowFunc()
+	settings := m.settings()
+	width := failureBucketWidth(settings.findTime)
+	key := m.trackingKey(clientIP, reqPath)
+
+	m.mu.Lock()
+
+	window, ok := m.requests[key]
+	if ok {
+		m.requestOrder.MoveToFront(window.elem)
+	} else {
+		m.evictTrackedIPs(m.maxTrackedIPs - 1)
+		window = &failureWindow{width: width, ip: clientIP, elem: m.requestOrder.PushFront(key)}
+		m.requests[key] = window
+		if m.trackByPath {
+			if m.trackedKeys[clientIP] == nil {
+				m.trackedKeys[clientIP] = make(map[string]struct{})
+			}
+			m.trackedKeys[clientIP][key] = struct{}{}
+		}
+	}
+
+	if window.width != width {
+		*window = failureWindow{width: width, ip: window.ip, elem: window.elem}
+	}
+	if failures, _, _ := window.counts(now); failures == 0 {
+		window.graceUsed = 0
+	}
+	if window.graceUsed < m.gracePeriodRequests {
+		window.graceUsed++
+		score = 0
+	}
+	window.add(now, score)
+	_, counted, total := window.counts(now)
+	distinct := 0
+	if m.distinctPaths > 0 && score > 0 {
+		distinct = window.addDistinctPath(reqPath, now, settings.findTime)
+	}
+	scanning := distinct > m.distinctPaths
+
+	banKey := clientIP
+	if prefix := m.banPrefix(clientIP); prefix != "" {
+		banKey = prefix
+	}
+	probation := m.onProbation(banKey, now)
+
+	if total > settings.maxRequests || probation || scanning {
+		key := banKey
+		banTime, count := m.nextBanTime(key, now)
+		reason := fmt.Sprintf("%d failures within %s", counted, settings.findTime)
+		if m.scoring {
+			reason = fmt.Sprintf("score %d from %d failures within %s", total, counted, settings.findTime)
+		}
+		if scanning {
+			reason = fmt.Sprintf("about %d distinct paths within %s, over %d", distinct, settings.findTime, m.distinctPaths)
+		}
+		if probation {
+			reason = "failure on probation"
+			delete(m.probation, m.storedKey(key))
+			m.probationCounts.rebanned++
+		}
+		if m.trackByPath {
+			reason += " on " + m.trackedPath(reqPath)
+		}
+		b := ban{
+			rule:   ruleRate,
+			reason: reason,
+			soft:   m.challengeURL != nil,
+			auth:   status == http.StatusUnauthorized,
+			start:  now,
+			hits:   &banHits{},
+		}
+		if count > 0 {
+			b.reason += fmt.Sprintf("; offense %d, banned for %s", count, banTime)
+		}
+		if banTime > 0 {
+			b.expiry = now.Add(banTime)
+		}
+		m.bans.Set(key, b)
+		m.forgetClient(clientIP)
+		m.mu.Unlock()
+
+		if key == clientIP {
+			m.cache.invalidate(clientIP)
+			m.logger.Info("Banning IP", "ip", m.logIP(clientIP), "reason", b.reason, "banTime", banTime)
+		} else {
+			// Other IPs of the range may have cached decisions.
+			m.cache.purge()
+			m.logger.Info("Banning IP range", "ip", m.logIP(clientIP), "range", m.logIP(key), "reason", b.reason, "banTime", banTime)
+		}
+		m.shareBan(key, b)
+		m.notifyBan(key, b, now, traceID)
+		return
+	}
+
+	m.metrics.setTrackedIPs(len(m.requests))
+	m.mu.Unlock()
+}
+
+// banPrefix returns the range automatic bans of clientIP cover, in CIDR
+// notation, or "" when they cover the single address.
+func (m *Fail2BanMiddleware) banPrefix(clientIP string) string {
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return ""
+	}
+
+	ones := m.ipv6BanPrefix
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		ones = m.ipv4BanPrefix
+		bits = 8 * net.IPv4len
+	}
+	if ones == 0 {
+		return ""
+	}
+
+	mask := net.CIDRMask(ones, bits)
+	return (&net.IPNet{IP: ip.Mask(mask), Mask: mask}).String()
+}
+
+// banKeys returns the keys of m.bans that apply to clientIP: the IP and, with
+// a ban prefix for its family, its range.
+func (m *Fail2BanMiddleware) banKeys(clientIP string) []string {
+	if prefix := m.banPrefix(clientIP); prefix != "" {
+		return []string{clientIP, prefix}
+	}
+	return []string{clientIP}
+}
+
+// failureBuckets is how many buckets a failure window is split into. Windows
+// are sliding at the granularity of findTime / failureBuckets.
+const failureBuckets = 10
+
+// failureWindow counts the recent failures of a tracking key in a ring of
+// buckets, each covering width, so a key costs the same however often it
+// fails. It also holds the time of the latest failure, how many failures were
+// let off as grace since the window was last empty, the IP it belongs to, its
+// element in m.requestOrder and, with DistinctPathThreshold, the sketch of the
+// paths it failed on.
+type failureWindow struct {
+	buckets   [failureBuckets]failureBucket
+	width     time.Duration
+	latest    time.Time
+	graceUsed int
+	ip        string
+	elem      *list.Element
+	paths     *pathSketch // nil until DistinctPathThreshold counts a path
+}
+
+// failureBucket counts the failures of one width-long slot of time: all of
+// them, those with a nonzero score, and their score.
+type failureBucket struct {
+	slot     int64 // the time divided by width
+	failures int
+	scored   int
+	score    int
+}
+
+// failureBucketWidth returns the span of the buckets of windows of findTime.
+func failureBucketWidth(findTime time.Duration) time.Duration {
+	if width := findTime / failureBuckets; width > 0 {
+		return width
+	}
+	return 1
+}
+
+// add records a failure with score at now.
+func (w *failureWindow) add(now time.Time, score int) {
+	slot := now.UnixNano() / int64(w.width)
+	b := &w.buckets[slot%failureBuckets]
+	if b.slot != slot {
+		*b = failureBucket{slot: slot}
+	}
+
+	b.failures++
+	if score != 0 {
+		b.scored++
+	}
+	b.score += score
+
+	if now.After(w.latest) {
+		w.latest = now
+	}
+}
+
+// counts returns the failures within the window ending at now: all of them,
+// those with a nonzero score, and their total score.
+func (w *failureWindow) counts(now time.Time) (failures, scored, score int) {
+	current := now.UnixNano() / int64(w.width)
+	for i := range w.buckets {
+		b := &w.buckets[i]
+		if b.slot > current-failureBuckets && b.slot <= current {
+			failures += b.failures
+			scored += b.scored
+			score += b.score
+		}
+	}
+
+	return failures, scored, score
+}
+
+// trackingKey returns the key the failures of clientIP on reqPath are counted
+// under: the IP, or with trackByPath the IP and the tracked part of the path.
+func (m *Fail2BanMiddleware) trackingKey(clientIP, reqPath string) string {
+	if !m.trackByPath {
+		return clientIP
+	}
+
+	return clientIP + " " + m.trackedPath(reqPath)
+}
+
+// trackedPath returns the cleaned reqPath, cut to its first trackPathSegments
+// segments when that is positive.
+func (m *Fail2BanMiddleware) trackedPath(reqPath string) string {
+	p := path.Clean("/" + reqPath)
+	if m.trackPathSegments <= 0 {
+		return p
+	}
+
+	segments := strings.SplitN(p[1:], "/", m.trackPathSegments+1)
+	if len(segments) > m.trackPathSegments {
+		segments = segments[:m.trackPathSegments]
+	}
+
+	return "/" + strings.Join(segments, "/")
+}
+
+// failureScore returns the score of a failed response with status for path.
+// Without ScoreThreshold every failure scores 1.
+func (m *Fail2BanMiddleware) failureScore(status int, path string) int {
+	if !m.scoring {
+		return 1
+	}
+
+	score, ok := m.statusScores[status]
+	if !ok {
+		score = 1
+	}
+
+	pathScore := 0
+	longest := -1
+	for prefix, s := range m.pathScores {
+		if len(prefix) > longest && strings.HasPrefix(path, prefix) {
+			pathScore = s
+			longest = len(prefix)
+		}
+	}
+
+	return score + pathScore
+}
+
+// parseScores splits the Scores configuration into the scores by status code
+// and by path prefix. Keys must be a status code or start with "/".
+func parseScores(scores map[string]int) (map[int]int, map[string]int, error) {
+	statusScores := make(map[int]int)
+	pathScores := make(map[string]int)
+	for key, score := range scores {
+		if strings.HasPrefix(key, "/") {
+			pathScores[key] = score
+			continue
+		}
+
+		code, err := strconv.Atoi(key)
+		if err != nil || code < 100 || code > 599 {
+			return nil, nil, fmt.Errorf("invalid scores key %q, want a status code or a path starting with /", key)
+		}
+		statusScores[code] = score
+	}
+
+	return statusScores, pathScores, nil
+}
+
+// forgetClient stops tracking the failures of clientIP, on every path with
+// trackByPath, its honeypot suspicion and its probation. The caller must hold
+// m.mu for writing.
+func (m *Fail2BanMiddleware) forgetClient(clientIP string) {
+	delete(m.suspects, clientIP)
+	delete(m.probation, m.storedKey(clientIP))
+	if !m.trackByPath {
+		m.forgetRequests(clientIP)
+		return
+	}
+
+	keys := make([]string, 0, len(m.trackedKeys[clientIP]))
+	for key := range m.trackedKeys[clientIP] {
+		keys = append(keys, key)
+	}
+	for _, key := range keys {
+		m.forgetRequests(key)
+	}
+}
+
+// forgetRequests stops tracking the failures counted under key. The caller
+// must hold m.mu for writing.
+func (m *Fail2BanMiddleware) forgetRequests(key string) {
+	window, ok := m.requests[key]
+	if !ok {
+		return
+	}
+
+	m.requestOrder.Remove(window.elem)
+	delete(m.requests, key)
+	if keys, ok := m.trackedKeys[window.ip]; ok {
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(m.trackedKeys, window.ip)
+		}
+	}
+	m.metrics.setTrackedIPs(len(m.requests))
+}
+
+// evictTrackedIPs drops the least recently failing keys until at most n are
+// tracked. It does nothing when maxTrackedIPs is unlimited. The caller must
+// hold m.mu for writing.
+func (m *Fail2BanMiddleware) evictTrackedIPs(n int) {
+	if m.maxTrackedIPs <= 0 {
+		return
+	}
+	for len(m.requests) > n {
+		m.forgetRequests(m.requestOrder.Back().Value.(string))
+	}
+}
+
+// sweepRequests periodically stops tracking keys whose latest failure has slid
+// out of findTime, so IPs that never reach the threshold don't accumulate, and
+// forgets the offense counts that have decayed. It sweeps every findTime as of
+// startup, and returns once m.ctx is cancelled.
+func (m *Fail2BanMiddleware) sweepRequests() {
+	ticker := time.NewTicker(m.settings().findTime)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			now := m.nowFunc()
+			cutoff := now.Add(-m.settings().findTime)
+
+			m.mu.Lock()
+			// requestOrder runs from most to least recent failure.
+			for elem := m.requestOrder.Back(); elem != nil; elem = m.requestOrder.Back() {
+				key := elem.Value.(string)
+				if m.requests[key].latest.After(cutoff) {
+					break
+				}
+				m.forgetRequests(key)
+			}
+			m.forgetBanCounts(now)
+			m.mu.Unlock()
+		}
+	}
+}
+
+// banCount tracks how often an IP has been banned, for progressive bans.
+type banCount struct {
+	count      int
+	lastBanEnd time.Time
+}
+
+// nextBanTime returns how long a new automatic ban on clientIP lasts. With
+// progressive bans enabled it also records the offense and returns its number,
+// and the n-th offense lasts baseBanTime * 2^(n-1) capped at maxBanTime;
+// otherwise every ban lasts banTime and count is zero. The caller must hold
+// m.mu for writing.
+func (m *Fail2BanMiddleware) nextBanTime(clientIP string, now time.Time) (banTime time.Duration, count int) {
+	settings := m.settings()
+	if settings.baseBanTime <= 0 {
+		return settings.banTime, 0
+	}
+
+	c := m.banCounts[clientIP]
+	if banCountDecayed(c, now, settings.resetAfter) {
+		c.count = 0
+	}
+	c.count++
+
+	banTime = settings.baseBanTime
+	for i := 1; i < c.count && banTime < settings.maxBanTime; i++ {
+		banTime *= 2
+	}
+	if banTime > settings.maxBanTime {
+		banTime = settings.maxBanTime
+	}
+
+	c.lastBanEnd = now.Add(banTime)
+	m.banCounts[clientIP] = c
+
+	return banTime, c.count
+}
+
+// banCountDecayed reports whether the offense count c is reset at now: once the
+// IP has behaved for resetAfter since its previous ban ended, or with
+// resetAfter zero as soon as that ban has ended.
+func banCountDecayed(c banCount, now time.Time, resetAfter time.Duration) bool {
+	if c.lastBanEnd.IsZero() {
+		return false
+	}
+
+	return now.Sub(c.lastBanEnd) > resetAfter
+}
+
+// forgetBanCounts drops the offense counts that have decayed by now, so
+// m.banCounts only holds IPs whose next ban would still be longer. The caller
+// must hold m.mu for writing.
+func (m *Fail2BanMiddleware) forgetBanCounts(now time.Time) {
+	resetAfter := m.settings().resetAfter
+	for ip, c := range m.banCounts {
+		if banCountDecayed(c, now, resetAfter) {
+			delete(m.banCounts, ip)
+		}
+	}
+}
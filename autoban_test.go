@@ -0,0 +1,152 @@
+package traefik_plugin
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// BenchmarkFailureWindow measures recording failures in a window and counting
+// them, with failures a second apart so the ring of buckets keeps turning, and
+// compares recording and counting each failure with the slice of failure
+// times the ring replaced.
+func BenchmarkFailureWindow(b *testing.B) {
+	findTime := 10 * time.Minute
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	b.Run("add", func(b *testing.B) {
+		w := &failureWindow{width: failureBucketWidth(findTime)}
+		for i := 0; i < b.N; i++ {
+			w.add(start.Add(time.Duration(i)*time.Second), 1)
+		}
+	})
+	b.Run("counts", func(b *testing.B) {
+		w := &failureWindow{width: failureBucketWidth(findTime)}
+		for i := 0; i < 1000; i++ {
+			w.add(start.Add(time.Duration(i)*time.Second), 1)
+		}
+		now := start.Add(1000 * time.Second)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			w.counts(now)
+		}
+	})
+	b.Run("ring", func(b *testing.B) {
+		w := &failureWindow{width: failureBucketWidth(findTime)}
+		for i := 0; i < b.N; i++ {
+			now := start.Add(time.Duration(i) * time.Second)
+			w.add(now, 1)
+			w.counts(now)
+		}
+	})
+	b.Run("slice", func(b *testing.B) {
+		var failures []time.Time
+		for i := 0; i < b.N; i++ {
+			now := start.Add(time.Duration(i) * time.Second)
+			failures = append(failures, now)
+			cutoff := now.Add(-findTime)
+			kept := failures[:0]
+			for _, t := range failures {
+				if t.After(cutoff) {
+					kept = append(kept, t)
+				}
+			}
+			failures = kept // counted by its length
+		}
+	})
+	b.Run("recordFailure", func(b *testing.B) {
+		m := newTestMiddleware(b, func(c *Config) { c.MaxRequests = 1 << 30 })
+		now := start
+		m.nowFunc = func() time.Time { return now }
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			now = start.Add(time.Duration(i) * time.Second)
+			m.recordFailure("203.0.113.7", "/fail", "", 401, 1)
+		}
+	})
+}
+
+// TestBanWithFakeClock moves the clock to slide the failure window past old
+// failures and to run a ban out.
+func TestBanWithFakeClock(t *testing.T) {
+	m := newTestMiddleware(t, func(c *Config) {
+		c.MaxRequests = 2
+		c.FindTime = 10 * time.Minute
+		c.BanTime = time.Hour
+	})
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	m.nowFunc = func() time.Time { return now }
+	status := func() int { return serve(m, "203.0.113.7:4321", "/", nil).Code }
+
+	serve(m, "203.0.113.7:4321", "/fail", nil)
+	serve(m, "203.0.113.7:4321", "/fail", nil)
+
+	// The first failures have left the window by the third one.
+	now = now.Add(11 * time.Minute)
+	serve(m, "203.0.113.7:4321", "/fail", nil)
+	if got := status(); got != http.StatusOK {
+		t.Fatalf("after failures spread over more than findTime: status = %d, want %d", got, http.StatusOK)
+	}
+
+	now = now.Add(time.Minute)
+	serve(m, "203.0.113.7:4321", "/fail", nil)
+	serve(m, "203.0.113.7:4321", "/fail", nil)
+	if got := status(); got != http.StatusForbidden {
+		t.Fatalf("after 3 failures within findTime: status = %d, want %d", got, http.StatusForbidden)
+	}
+
+	now = now.Add(time.Hour - time.Second)
+	if got := status(); got != http.StatusForbidden {
+		t.Fatalf("before the ban ends: status = %d, want %d", got, http.StatusForbidden)
+	}
+	now = now.Add(2 * time.Second)
+	if got := status(); got != http.StatusOK {
+		t.Errorf("after the ban ended: status = %d, want %d", got, http.StatusOK)
+	}
+}
+
+func TestForgetBanCounts(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		resetAfter time.Duration
+		want       []string
+	}{
+		{time.Hour, []string{"banned", "ended", "ended resetAfter ago"}},
+		{0, []string{"banned", "ended resetAfter ago"}}, // that is, ending now
+	}
+	for _, tt := range tests {
+		t.Run(tt.resetAfter.String(), func(t *testing.T) {
+			m := newTestMiddleware(t, func(c *Config) {
+				c.MaxRequests = 5
+				c.BaseBanTime = time.Minute
+				c.MaxBanTime = time.Hour
+				c.ResetAfter = tt.resetAfter
+			})
+
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			m.banCounts = map[string]banCount{
+				"banned":                  {count: 3, lastBanEnd: now.Add(time.Minute)},
+				"ended":                   {count: 2, lastBanEnd: now.Add(-time.Second)},
+				"ended resetAfter ago":    {count: 2, lastBanEnd: now.Add(-tt.resetAfter)},
+				"ended before resetAfter": {count: 1, lastBanEnd: now.Add(-tt.resetAfter - time.Second)},
+				"ended long before":       {count: 4, lastBanEnd: now.Add(-48 * time.Hour)},
+			}
+			m.forgetBanCounts(now)
+
+			got := make(map[string]bool, len(m.banCounts))
+			for ip := range m.banCounts {
+				got[ip] = true
+			}
+			want := make(map[string]bool, len(tt.want))
+			for _, ip := range tt.want {
+				want[ip] = true
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("counts kept %v, want %v", got, want)
+			}
+		})
+	}
+}
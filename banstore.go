@@ -0,0 +1,176 @@
+package traefik_plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BanStore holds dynamic bans by key, an IP or a range in CIDR notation. The
+// middleware keeps its own bans in a memory store and, with RedisURL, shares
+// them with other nodes through a Redis store; other backends only need to
+// implement this interface.
+type BanStore interface {
+	// Get returns the ban on key, expired or not.
+	Get(key string) (ban, bool)
+	// Set bans key with b, replacing any ban on it.
+	Set(key string, b ban)
+	// Delete lifts the ban on key.
+	Delete(key string)
+	// List returns the bans by key.
+	List() map[string]ban
+}
+
+// memoryBanStore is the BanStore of the bans of one middleware. It isn't
+// synchronized: the middleware guards it with its mu.
+type memoryBanStore struct {
+	bans map[string]ban
+}
+
+func newMemoryBanStore() *memoryBanStore {
+	return &memoryBanStore{bans: make(map[string]ban)}
+}
+
+func (s *memoryBanStore) Get(key string) (ban, bool) {
+	b, ok := s.bans[key]
+	return b, ok
+}
+
+func (s *memoryBanStore) Set(key string, b ban) { s.bans[key] = b }
+
+func (s *memoryBanStore) Delete(key string) { delete(s.bans, key) }
+
+// List returns a copy of the bans, so the caller may change the store while
+// ranging over them.
+func (s *memoryBanStore) List() map[string]ban {
+	bans := make(map[string]ban, len(s.bans))
+	for key, b := range s.bans {
+		bans[key] = b
+	}
+
+	return bans
+}
+
+// redisBanStore is the BanStore shared through the Redis server of m. Its
+// methods talk to Redis and must be called without holding m.mu. Failures
+// are logged by redisDo and read as no ban.
+type redisBanStore struct {
+	m *Fail2BanMiddleware
+}
+
+// Get returns the shared ban on key, caching lookups for redisCacheTTL.
+func (s redisBanStore) Get(key string) (ban, bool) {
+	r := s.m.redis
+	now := s.m.nowFunc()
+	if d, ok := r.cache.get(key, now); ok {
+		return d.ban, d.blocked
+	}
+
+	gen := r.cache.generation()
+	var d decision
+	reply, err := s.m.redisDo("GET", redisKeyPrefix+key)
+	switch {
+	case errors.Is(err, errRedisNil):
+	case err != nil:
+		// Don't cache failures, so the ban is picked up once Redis is back.
+		return ban{}, false
+	default:
+		d.ban, d.blocked = s.decode(key, reply)
+	}
+
+	r.cache.put(key, d, now, gen)
+
+	return d.ban, d.blocked
+}
+
+// Set stores b on key, with the ban's remaining time as TTL.
+func (s redisBanStore) Set(key string, b ban) {
+	value, err := json.Marshal(newBanEntry(key, b))
+	if err != nil {
+		return
+	}
+
+	args := []string{"SET", redisKeyPrefix + key, string(value)}
+	if !b.expiry.IsZero() {
+		ttl := b.expiry.Sub(s.m.nowFunc())
+		if ttl <= 0 {
+			return
+		}
+		args = append(args, "EX", strconv.FormatInt(int64((ttl+time.Second-1)/time.Second), 10))
+	}
+
+	if _, err := s.m.redisDo(args...); err == nil {
+		s.m.redis.cache.invalidate(key)
+	}
+}
+
+// Delete removes the shared ban on key.
+func (s redisBanStore) Delete(key string) {
+	if _, err := s.m.redisDo("DEL", redisKeyPrefix+key); err == nil {
+		s.m.redis.cache.invalidate(key)
+	}
+}
+
+// List scans Redis for the shared bans. It returns those read before any
+// failure.
+func (s redisBanStore) List() map[string]ban {
+	bans := make(map[string]ban)
+	cursor := "0"
+	for {
+		reply, err := s.m.redisDo("SCAN", cursor, "MATCH", redisKeyPrefix+"*", "COUNT", "100")
+		if err != nil {
+			return bans
+		}
+		page, ok := reply.([]interface{})
+		if !ok || len(page) != 2 {
+			return bans
+		}
+		keys, _ := page[1].([]interface{})
+		for _, k := range keys {
+			redisKey, _ := k.(string)
+			key := strings.TrimPrefix(redisKey, redisKeyPrefix)
+			value, err := s.m.redisDo("GET", redisKey)
+			if err != nil {
+				continue
+			}
+			if b, ok := s.decode(key, value); ok {
+				bans[key] = b
+			}
+		}
+
+		cursor, _ = page[0].(string)
+		if cursor == "0" || cursor == "" {
+			return bans
+		}
+	}
+}
+
+// decode reads the shared ban on key from a GET reply.
+func (s redisBanStore) decode(key string, reply interface{}) (ban, bool) {
+	value, _ := reply.(string)
+	var entry banEntry
+	if err := json.Unmarshal([]byte(value), &entry); err != nil {
+		s.m.logger.Warn("Invalid shared ban", "ip", s.m.logIP(key), "error", err)
+		return ban{}, false
+	}
+
+	return entry.toBan(), true
+}
+
+// shareBan stores b on key in the shared store, if any. It must be called
+// without holding m.mu.
+func (m *Fail2BanMiddleware) shareBan(key string, b ban) {
+	if m.sharedBans != nil {
+		m.sharedBans.Set(key, b)
+	}
+}
+
+// unshareBan removes the ban on key from the shared store, if any. It must be
+// called without holding m.mu.
+func (m *Fail2BanMiddleware) unshareBan(key string) {
+	if m.sharedBans != nil {
+		m.sharedBans.Delete(key)
+	}
+}
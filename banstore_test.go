@@ -0,0 +1,259 @@
+package traefik_plugin
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a Redis server speaking enough RESP for redisBanStore: GET,
+// SET with EX, DEL and SCAN with a MATCH prefix pattern. Keys don't expire;
+// the TTL each SET asked for is kept in ttls.
+type fakeRedis struct {
+	ln net.Listener
+
+	mu     sync.Mutex
+	values map[string]string
+	ttls   map[string]string
+}
+
+// newFakeRedis starts a fakeRedis on a loopback port, stopped when t ends.
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeRedis{ln: ln, values: make(map[string]string), ttls: make(map[string]string)}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+
+	return r
+}
+
+// url returns the redis:// URL of r.
+func (r *fakeRedis) url() string { return "redis://" + r.ln.Addr().String() }
+
+// ttl returns the TTL the last SET of key asked for, "" for none.
+func (r *fakeRedis) ttl(key string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.ttls[key]
+}
+
+func (r *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	for {
+		args, err := readRESPCommand(reader)
+		if err != nil {
+			return
+		}
+		if _, err := io.WriteString(conn, r.exec(args)); err != nil {
+			return
+		}
+	}
+}
+
+// exec runs a command and returns its RESP reply.
+func (r *fakeRedis) exec(args []string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		value, ok := r.values[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return respBulk(value)
+	case "SET":
+		r.values[args[1]] = args[2]
+		delete(r.ttls, args[1])
+		if len(args) == 5 && strings.ToUpper(args[3]) == "EX" {
+			r.ttls[args[1]] = args[4]
+		}
+		return "+OK\r\n"
+	case "DEL":
+		if _, ok := r.values[args[1]]; !ok {
+			return ":0\r\n"
+		}
+		delete(r.values, args[1])
+		delete(r.ttls, args[1])
+		return ":1\r\n"
+	case "SCAN":
+		// One page holding every match.
+		var keys []string
+		for key := range r.values {
+			if strings.HasPrefix(key, strings.TrimSuffix(args[3], "*")) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		reply := "*2\r\n" + respBulk("0") + fmt.Sprintf("*%d\r\n", len(keys))
+		for _, key := range keys {
+			reply += respBulk(key)
+		}
+		return reply
+	default:
+		return "-ERR unknown command\r\n"
+	}
+}
+
+// readRESPCommand reads a command sent as a RESP array of bulk strings.
+func readRESPCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid command %q", line)
+	}
+
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, fmt.Errorf("invalid bulk string %q", line)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+
+	return args, nil
+}
+
+func respBulk(value string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+}
+
+// testNow is the time the ban store tests run at.
+var testNow = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// banStores returns the stores the ban store tests run against, by name: a
+// memory store, and a Redis store talking to a fakeRedis at testNow.
+func banStores(t *testing.T) map[string]BanStore {
+	redis := newFakeRedis(t)
+	m := newTestMiddleware(t, func(c *Config) { c.RedisURL = redis.url() })
+	m.nowFunc = func() time.Time { return testNow }
+
+	return map[string]BanStore{
+		"memory": newMemoryBanStore(),
+		"redis":  m.sharedBans,
+	}
+}
+
+func TestBanStore(t *testing.T) {
+	expiry := testNow.Add(time.Hour)
+
+	for name, store := range banStores(t) {
+		t.Run(name, func(t *testing.T) {
+			if _, ok := store.Get("192.0.2.1"); ok {
+				t.Fatal("Get on an empty store found a ban")
+			}
+
+			store.Set("192.0.2.1", ban{expiry: expiry, rule: ruleRate, reason: "too many"})
+			store.Set("2001:db8::/64", ban{rule: ruleManual, note: "permanent"})
+
+			b, ok := store.Get("192.0.2.1")
+			switch {
+			case !ok:
+				t.Fatal("Get didn't find the ban set")
+			case !b.expiry.Equal(expiry) || b.rule != ruleRate || b.reason != "too many":
+				t.Errorf("Get = %+v, want the ban set", b)
+			}
+			if b, ok := store.Get("2001:db8::/64"); !ok || !b.expiry.IsZero() || b.note != "permanent" {
+				t.Errorf("Get of the permanent ban = %+v, %v", b, ok)
+			}
+
+			store.Set("192.0.2.1", ban{expiry: expiry, rule: ruleManual})
+			if b, _ := store.Get("192.0.2.1"); b.rule != ruleManual {
+				t.Errorf("Set didn't replace the ban: rule = %q, want %q", b.rule, ruleManual)
+			}
+
+			if bans := store.List(); len(bans) != 2 || bans["192.0.2.1"].rule != ruleManual || bans["2001:db8::/64"].note != "permanent" {
+				t.Errorf("List = %+v, want both bans", bans)
+			}
+
+			store.Delete("192.0.2.1")
+			if _, ok := store.Get("192.0.2.1"); ok {
+				t.Error("Get found a deleted ban")
+			}
+			if bans := store.List(); len(bans) != 1 {
+				t.Errorf("List after Delete has %d bans, want 1", len(bans))
+			}
+		})
+	}
+}
+
+// TestRedisBanStoreTTL checks that the TTL of a shared ban is its time left on
+// the middleware's clock, and that bans already over aren't shared.
+func TestRedisBanStoreTTL(t *testing.T) {
+	redis := newFakeRedis(t)
+	m := newTestMiddleware(t, func(c *Config) { c.RedisURL = redis.url() })
+	m.nowFunc = func() time.Time { return testNow }
+
+	m.sharedBans.Set("192.0.2.1", ban{expiry: testNow.Add(90 * time.Second), rule: ruleRate})
+	if ttl := redis.ttl(redisKeyPrefix + "192.0.2.1"); ttl != "90" {
+		t.Errorf("TTL = %q, want %q", ttl, "90")
+	}
+
+	m.sharedBans.Set("192.0.2.2", ban{expiry: testNow.Add(-time.Second), rule: ruleRate})
+	if _, ok := m.sharedBans.Get("192.0.2.2"); ok {
+		t.Error("a ban already over was shared")
+	}
+}
+
+// TestRedisRetryWithFakeClock checks that Redis is skipped for
+// redisRetryInterval on the middleware's clock after a failure.
+func TestRedisRetryWithFakeClock(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close() // nothing listens, so connecting fails
+
+	m := newTestMiddleware(t, func(c *Config) { c.RedisURL = "redis://" + addr })
+	now := testNow
+	m.nowFunc = func() time.Time { return now }
+
+	if _, err := m.redisDo("GET", "key"); err == nil || err.Error() == "redis unavailable" {
+		t.Fatalf("first command: %v, want a connection error", err)
+	}
+	now = now.Add(redisRetryInterval - time.Second)
+	if _, err := m.redisDo("GET", "key"); err == nil || err.Error() != "redis unavailable" {
+		t.Fatalf("command within redisRetryInterval: %v, want Redis skipped", err)
+	}
+	now = now.Add(2 * time.Second)
+	if _, err := m.redisDo("GET", "key"); err == nil || err.Error() == "redis unavailable" {
+		t.Errorf("command after redisRetryInterval: %v, want another connection attempt", err)
+	}
+}
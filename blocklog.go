@@ -0,0 +1,108 @@
+package traefik_plugin
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Formats available to Config.BlockLogFormat.
+const (
+	blockLogCLF      = "clf"
+	blockLogCombined = "combined"
+)
+
+// clfTimeFormat is the timestamp format of the Common Log Format.
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// blockLog writes a line in the Common or Combined Log Format for each blocked
+// request.
+type blockLog struct {
+	mu       sync.Mutex
+	w        io.Writer
+	closer   io.Closer // nil for stdout and stderr
+	combined bool
+}
+
+// openBlockLog opens the BlockLogPath destination in the given format:
+// stdout when path is empty or "stdout", stderr for "stderr", and otherwise
+// the file at path, which is appended to.
+func openBlockLog(format, path string) (*blockLog, error) {
+	l := &blockLog{combined: format == blockLogCombined}
+
+	switch path {
+	case "", "stdout":
+		l.w = os.Stdout
+	case "stderr":
+		l.w = os.Stderr
+	default:
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		l.w = f
+		l.closer = f
+	}
+
+	return l, nil
+}
+
+// write logs the response with status and size sent to req from clientIP at
+// now.
+func (l *blockLog) write(req *http.Request, clientIP string, now time.Time, status int, size int64) {
+	uri := req.RequestURI
+	if uri == "" {
+		uri = req.URL.RequestURI()
+	}
+
+	bytes := "-"
+	if size > 0 {
+		bytes = strconv.FormatInt(size, 10)
+	}
+
+	line := clientIP + " - - [" + now.Format(clfTimeFormat) + "] " +
+		strconv.Quote(req.Method+" "+uri+" "+req.Proto) + " " + strconv.Itoa(status) + " " + bytes
+	if l.combined {
+		line += " " + quoteOrDash(req.Referer()) + " " + quoteOrDash(req.UserAgent())
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = io.WriteString(l.w, line+"\n")
+}
+
+// close closes the block log file, if any.
+func (l *blockLog) close() error {
+	if l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
+}
+
+// quoteOrDash returns s quoted for a log line, or "-" when it is empty.
+func quoteOrDash(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+	return strconv.Quote(s)
+}
+
+// logBlocked runs write, which sends the block response for req from
+// clientIP, and logs the response to the block log if there is one. Nothing
+// is logged when write sent nothing, as when the client went away during the
+// tarpit delay.
+func (m *Fail2BanMiddleware) logBlocked(rw http.ResponseWriter, req *http.Request, clientIP string, now time.Time, write func(rw http.ResponseWriter)) {
+	if m.blockLog == nil {
+		write(rw)
+		return
+	}
+
+	capture := &responseRecorder{ResponseWriter: rw}
+	write(capture)
+	if capture.status != 0 {
+		m.blockLog.write(req, m.logIP(clientIP), now, capture.status, capture.size)
+	}
+}
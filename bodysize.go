@@ -0,0 +1,101 @@
+package traefik_plugin
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// BodySizeRule matches requests to path prefixes that shouldn't receive large
+// bodies, to catch abusive uploads. A rule matches when the request's
+// Content-Length exceeds MaxBytes; requests of unknown length, such as chunked
+// uploads, never match. A matching rule blocks the request, or with a
+// positive Score adds that score toward the automatic ban of its IP instead.
+type BodySizeRule struct {
+	// Name is reported as the reason of blocks. Defaults to a description
+	// of the limit.
+	Name     string   `json:"name"`
+	Paths    []string `json:"paths"`
+	MaxBytes int64    `json:"maxBytes"`
+	Score    int      `json:"score"`
+}
+
+// bodySizeRule is a validated BodySizeRule.
+type bodySizeRule struct {
+	name     string
+	paths    []string
+	maxBytes int64
+	score    int
+}
+
+// parseBodySizeRules checks the BodySizeRules configuration. scoring tells
+// whether automatic banning is enabled, which rules with a score need.
+func parseBodySizeRules(rules []BodySizeRule, scoring bool) ([]bodySizeRule, error) {
+	parsed := make([]bodySizeRule, 0, len(rules))
+	for i, rule := range rules {
+		if len(rule.Paths) == 0 {
+			return nil, fmt.Errorf("bodySizeRules[%d]: paths is required", i)
+		}
+		for _, path := range rule.Paths {
+			if !strings.HasPrefix(path, "/") {
+				return nil, fmt.Errorf("bodySizeRules[%d]: path %q must start with /", i, path)
+			}
+		}
+		if rule.MaxBytes < 0 {
+			return nil, fmt.Errorf("bodySizeRules[%d]: maxBytes cannot be negative", i)
+		}
+		if rule.Score < 0 {
+			return nil, fmt.Errorf("bodySizeRules[%d]: score cannot be negative", i)
+		}
+		if rule.Score > 0 && !scoring {
+			return nil, fmt.Errorf("bodySizeRules[%d]: score requires maxRequests or scoreThreshold", i)
+		}
+
+		r := bodySizeRule{
+			name:     rule.Name,
+			paths:    rule.Paths,
+			maxBytes: rule.MaxBytes,
+			score:    rule.Score,
+		}
+		if r.name == "" {
+			r.name = fmt.Sprintf("body over %d bytes", rule.MaxBytes)
+		}
+		parsed = append(parsed, r)
+	}
+
+	return parsed, nil
+}
+
+// matches reports whether req, for reqPath, matches the rule.
+func (r bodySizeRule) matches(req *http.Request, reqPath string) bool {
+	if req.ContentLength <= r.maxBytes {
+		return false
+	}
+
+	for _, path := range r.paths {
+		if strings.HasPrefix(reqPath, path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchBodySizeRules returns the first blocking rule req matches, or nil,
+// along with the total score of the scoring rules it matches.
+func (m *Fail2BanMiddleware) matchBodySizeRules(req *http.Request) (*bodySizeRule, int) {
+	score := 0
+	reqPath := m.requestPath(req)
+	for i := range m.bodySizeRules {
+		rule := &m.bodySizeRules[i]
+		if !rule.matches(req, reqPath) {
+			continue
+		}
+		if rule.score == 0 {
+			return rule, score
+		}
+		score += rule.score
+	}
+
+	return nil, score
+}
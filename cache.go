@@ -0,0 +1,149 @@
+package traefik_plugin
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// decision is the cached outcome of checking a client IP against the
+// allowlist, the dynamic bans, the blocklist and the blocked countries.
+type decision struct {
+	allowed bool // on the allowlist
+	blocked bool
+	ban     ban
+}
+
+// decisionCache is a fixed-size LRU of decisions by client IP. Entries live for
+// at most ttl so changes that don't invalidate the cache still propagate. A nil
+// *decisionCache is a disabled cache.
+type decisionCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List // of *cacheEntry, most recently used first
+	entries map[string]*list.Element
+	// gen is bumped by purge and invalidate, so decisions computed from
+	// state that changed meanwhile are not cached.
+	gen uint64
+}
+
+type cacheEntry struct {
+	ip       string
+	decision decision
+	expiry   time.Time
+}
+
+// newDecisionCache returns a cache holding up to size decisions, or nil when
+// size is zero.
+func newDecisionCache(size int, ttl time.Duration) *decisionCache {
+	if size <= 0 {
+		return nil
+	}
+	return &decisionCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element, size),
+	}
+}
+
+// get returns the cached decision for ip at now. Blocking decisions are not
+// returned past the expiry of their ban.
+func (c *decisionCache) get(ip string, now time.Time) (decision, bool) {
+	if c == nil {
+		return decision{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[ip]
+	if !ok {
+		return decision{}, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	expiry := entry.decision.ban.expiry
+	if !now.Before(entry.expiry) || (!expiry.IsZero() && !now.Before(expiry)) {
+		c.order.Remove(elem)
+		delete(c.entries, ip)
+		return decision{}, false
+	}
+	c.order.MoveToFront(elem)
+
+	return entry.decision, true
+}
+
+// generation returns the current generation of the cache. Callers read it
+// before computing a decision and pass it to put.
+func (c *decisionCache) generation() uint64 {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.gen
+}
+
+// put caches d for ip, evicting the least recently used entry when full. The
+// decision is dropped if the cache was purged or invalidated since gen was
+// read, as it may have been computed from the old state.
+func (c *decisionCache) put(ip string, d decision, now time.Time, gen uint64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen {
+		return
+	}
+
+	if elem, ok := c.entries[ip]; ok {
+		entry := elem.Value.(*cacheEntry)
+		entry.decision = d
+		entry.expiry = now.Add(c.ttl)
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	if c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).ip)
+	}
+	c.entries[ip] = c.order.PushFront(&cacheEntry{ip: ip, decision: d, expiry: now.Add(c.ttl)})
+}
+
+// invalidate drops the cached decision for ip.
+func (c *decisionCache) invalidate(ip string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	if elem, ok := c.entries[ip]; ok {
+		c.order.Remove(elem)
+		delete(c.entries, ip)
+	}
+}
+
+// purge drops all cached decisions.
+func (c *decisionCache) purge() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.order.Init()
+	c.entries = make(map[string]*list.Element, c.size)
+}
@@ -0,0 +1,218 @@
+package traefik_plugin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// maxCDNRangesBytes bounds a published list of edge ranges.
+const maxCDNRangesBytes = 1 << 20
+
+// cdnProvider is a CDN whose edge ranges and connecting-IP header are built
+// in, for Config.CDNProviders.
+type cdnProvider struct {
+	// header carries the client address the edge connected for.
+	header string
+	// ranges are the edge ranges, as published when this release was made.
+	ranges []string
+	// urls are where the provider publishes its current edge ranges, one
+	// CIDR per line.
+	urls []string
+}
+
+// cdnProviders are the providers available to Config.CDNProviders, by name.
+var cdnProviders = map[string]cdnProvider{
+	"cloudflare": {
+		header: "CF-Connecting-IP",
+		ranges: []string{
+			"173.245.48.0/20",
+			"103.21.244.0/22",
+			"103.22.200.0/22",
+			"103.31.4.0/22",
+			"141.101.64.0/18",
+			"108.162.192.0/18",
+			"190.93.240.0/20",
+			"188.114.96.0/20",
+			"197.234.240.0/22",
+			"198.41.128.0/17",
+			"162.158.0.0/15",
+			"104.16.0.0/13",
+			"104.24.0.0/14",
+			"172.64.0.0/13",
+			"131.0.72.0/22",
+			"2400:cb00::/32",
+			"2606:4700::/32",
+			"2803:f800::/32",
+			"2405:b500::/32",
+			"2405:8100::/32",
+			"2a06:98c0::/29",
+			"2c0f:f248::/32",
+		},
+		urls: []string{
+			"https://www.cloudflare.com/ips-v4",
+			"https://www.cloudflare.com/ips-v6",
+		},
+	},
+}
+
+// cdnEdge is a configured CDN provider, whose edge ranges refreshCDNRanges
+// may replace.
+type cdnEdge struct {
+	name   string
+	header string
+	urls   []string
+	nets   atomic.Value // []*net.IPNet
+}
+
+// validateCDNProviders checks the CDNProviders and CDNRefreshInterval
+// configuration.
+func validateCDNProviders(c *Config) error {
+	for i, name := range c.CDNProviders {
+		if _, ok := cdnProviders[strings.ToLower(name)]; !ok {
+			return fmt.Errorf("cdnProviders[%d]: unknown provider %q", i, name)
+		}
+	}
+	switch {
+	case c.CDNRefreshInterval < 0:
+		return errors.New("cdnRefreshInterval cannot be negative")
+	case c.CDNRefreshInterval > 0 && len(c.CDNProviders) == 0:
+		return errors.New("cdnRefreshInterval requires cdnProviders")
+	}
+
+	return nil
+}
+
+// newCDNEdges returns the edges of the validated CDNProviders, with their
+// built-in ranges.
+func newCDNEdges(names []string) ([]*cdnEdge, error) {
+	edges := make([]*cdnEdge, 0, len(names))
+	for _, name := range names {
+		name = strings.ToLower(name)
+		provider := cdnProviders[name]
+		nets, err := parseCIDRs(provider.ranges)
+		if err != nil {
+			return nil, err
+		}
+		edge := &cdnEdge{name: name, header: provider.header, urls: provider.urls}
+		edge.nets.Store(nets)
+		edges = append(edges, edge)
+	}
+
+	return edges, nil
+}
+
+// cdnClientIP returns the address in the connecting-IP header of the CDN
+// whose edge req came from, or "" if it came from none or the header carries
+// no usable address. Only the connection's remote address is compared with
+// the edge ranges, so clients connecting directly can't claim to be a CDN.
+func (m *Fail2BanMiddleware) cdnClientIP(req *http.Request) string {
+	remote := net.ParseIP(hostFromAddr(req.RemoteAddr))
+	if remote == nil {
+		return ""
+	}
+
+	for _, edge := range m.cdnEdges {
+		if !containsIP(edge.nets.Load().([]*net.IPNet), remote) {
+			continue
+		}
+		if ip := strings.TrimSpace(req.Header.Get(edge.header)); net.ParseIP(ip) != nil {
+			return ip
+		}
+		return ""
+	}
+
+	return ""
+}
+
+// refreshCDNRanges fetches the published edge ranges of the CDN providers
+// now and every cdnRefreshInterval until m.ctx is cancelled. A provider whose
+// ranges can't be fetched keeps those it has.
+func (m *Fail2BanMiddleware) refreshCDNRanges() {
+	ticker := time.NewTicker(m.cdnRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, edge := range m.cdnEdges {
+			nets, err := m.fetchCDNRanges(edge)
+			if err != nil {
+				m.logger.Warn("Failed to refresh CDN edge ranges, keeping the current ones", "provider", edge.name, "error", err)
+				continue
+			}
+			edge.nets.Store(nets)
+			m.logger.Debug("Refreshed CDN edge ranges", "provider", edge.name, "ranges", len(nets))
+		}
+
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fetchCDNRanges downloads and parses the published edge ranges of edge. It
+// fails unless every list loads and they hold at least one range.
+func (m *Fail2BanMiddleware) fetchCDNRanges(edge *cdnEdge) ([]*net.IPNet, error) {
+	var cidrs []string
+	for _, url := range edge.urls {
+		data, err := m.fetchCDNList(url)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				cidrs = append(cidrs, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if len(cidrs) == 0 {
+		return nil, errors.New("no ranges published")
+	}
+
+	return parseCIDRs(cidrs)
+}
+
+// fetchCDNList downloads the list at url, failing after fetchTimeout and for
+// bodies over maxCDNRangesBytes.
+func (m *Fail2BanMiddleware) fetchCDNList(url string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(m.ctx, m.fetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := m.fetchClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status fetching %s: %s", url, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxCDNRangesBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxCDNRangesBytes {
+		return nil, fmt.Errorf("%s exceeds %d bytes", url, maxCDNRangesBytes)
+	}
+
+	return data, nil
+}
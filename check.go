@@ -0,0 +1,305 @@
+package traefik_plugin
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// checkResult is the response of GET /check: the decision the middleware
+// would make for an IP and the verdict of each check that led to it.
+type checkResult struct {
+	IP      string `json:"ip"`
+	Blocked bool   `json:"blocked"`
+	// Rule and Reason are those of the check that would fire.
+	Rule      string         `json:"rule,omitempty"`
+	Reason    string         `json:"reason,omitempty"`
+	Enforcing bool           `json:"enforcing"`
+	Checks    []checkVerdict `json:"checks"`
+	Failures  []failureCount `json:"failures,omitempty"`
+	// SuspectUntil is when the IP stops being a honeypot suspect.
+	SuspectUntil *time.Time `json:"suspectUntil,omitempty"`
+	RateLimit    *rateState `json:"rateLimit,omitempty"`
+}
+
+// checkVerdict is the verdict of one check of the decision pipeline, in the
+// order ServeHTTP runs them. Checks past the one that fires are still
+// evaluated, except where Skipped says why not.
+type checkVerdict struct {
+	Check     string     `json:"check"`
+	Matched   bool       `json:"matched"`
+	Fires     bool       `json:"fires,omitempty"`
+	Key       string     `json:"key,omitempty"` // the banned IP or range
+	Rule      string     `json:"rule,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Expired   bool       `json:"expired,omitempty"`
+	Skipped   string     `json:"skipped,omitempty"`
+}
+
+// failureCount is the failure window of a tracking key toward an automatic
+// ban.
+type failureCount struct {
+	Key       string `json:"key"`
+	Failures  int    `json:"failures"`
+	Score     int    `json:"score"`
+	Threshold int    `json:"threshold"`
+	// DistinctPaths is the estimated number of distinct paths failed on,
+	// with DistinctPathThreshold.
+	DistinctPaths int `json:"distinctPaths,omitempty"`
+}
+
+// rateState is the token bucket of an IP under RateLimit.
+type rateState struct {
+	Limit   int     `json:"limit"`
+	Burst   int     `json:"burst"`
+	Tokens  float64 `json:"tokens"`
+	Limited bool    `json:"limited"`
+}
+
+// handleCheck explains how a request from the IP in the ip query parameter
+// would be decided, by running the allowlist, lockdown, dynamic bans, the
+// matcher chain and the failure and rate state against it. It has no side
+// effects: nothing is cached, counted, logged or cleaned up. Header rules
+// need a request and aren't evaluated, and custom matchers are passed a nil
+// request.
+func (m *Fail2BanMiddleware) handleCheck(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeMethodNotAllowed(rw, http.MethodGet)
+		return
+	}
+
+	raw := req.URL.Query().Get("ip")
+	ip := net.ParseIP(strings.TrimSpace(raw))
+	if ip == nil {
+		writeJSONError(rw, http.StatusBadRequest, fmt.Sprintf("invalid ip %q", raw))
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, m.check(normalizeIP(ip.String()), m.nowFunc()))
+}
+
+// check evaluates the decision pipeline for clientIP at now.
+func (m *Fail2BanMiddleware) check(clientIP string, now time.Time) checkResult {
+	result := checkResult{IP: clientIP, Enforcing: m.enforcing(now), Checks: []checkVerdict{}}
+
+	// add records the verdict of the next check, which fires if it is the
+	// first to match. Only the private range, temporary allow and allowlist
+	// checks fire without blocking.
+	decided := false
+	add := func(v checkVerdict) {
+		if !v.Matched {
+			v.Rule = ""
+		}
+		if v.Matched && v.Skipped == "" && !v.Expired && !decided {
+			decided = true
+			v.Fires = true
+			if v.Check != "privateRange" && v.Check != "tempAllow" && v.Check != "allowlist" {
+				result.Blocked = true
+				result.Rule = v.Rule
+				result.Reason = v.Reason
+			}
+		}
+		result.Checks = append(result.Checks, v)
+	}
+
+	if m.skipPrivateRanges {
+		add(checkVerdict{Check: "privateRange", Matched: isPrivateIP(clientIP)})
+	}
+	add(checkVerdict{Check: "tempAllow", Matched: m.tempAllowed(clientIP, now)})
+	if !m.blockFirst {
+		m.checkAllowlist(add, clientIP)
+	}
+
+	m.mu.RLock()
+	var bans []checkVerdict
+	for _, key := range m.banKeys(clientIP) {
+		if b, ok := m.bans.Get(key); ok {
+			bans = append(bans, banVerdict("ban", key, b, now))
+		}
+	}
+	m.mu.RUnlock()
+
+	if len(bans) == 0 {
+		bans = append(bans, checkVerdict{Check: "ban"})
+	}
+	for _, v := range bans {
+		add(v)
+	}
+
+	if m.sharedBans != nil {
+		shared := checkVerdict{Check: "sharedBan"}
+		for _, key := range m.banKeys(clientIP) {
+			if b, ok := m.sharedBans.Get(key); ok {
+				shared = banVerdict("sharedBan", key, b, now)
+				break
+			}
+		}
+		add(shared)
+	}
+
+	lifted := m.liftedEntries(clientIP, now)
+	for _, matcher := range m.matchers {
+		v := checkVerdict{Check: matcherName(matcher)}
+		if b, ok := matcher.match(clientIP, nil, now); ok {
+			key := ""
+			if b.cidr != nil {
+				key = b.cidr.String()
+			}
+			v = banVerdict(v.Check, key, b, now)
+		} else if m.liftedMatch(matcher, clientIP, lifted) {
+			v.Skipped = "unbanned via admin API"
+		}
+		add(v)
+	}
+
+	if m.blockFirst {
+		m.checkAllowlist(add, clientIP)
+	}
+
+	result.Failures = m.failureCounts(clientIP, now)
+	if until := m.suspectUntil(clientIP, now); !until.IsZero() {
+		until = until.UTC()
+		result.SuspectUntil = &until
+	}
+
+	if m.rateLimit > 0 {
+		tokens := m.rateTokens(clientIP, now)
+		result.RateLimit = &rateState{Limit: m.rateLimit, Burst: m.rateBurst, Tokens: tokens, Limited: tokens < 1}
+		add(checkVerdict{Check: "rateLimit", Matched: tokens < 1, Rule: ruleRateLimit})
+	}
+	if m.maxConcurrent > 0 {
+		add(checkVerdict{Check: "concurrency", Matched: m.inFlight(clientIP) >= m.maxConcurrent, Rule: ruleConcurrency})
+	}
+
+	return result
+}
+
+// checkAllowlist adds the verdicts of the allowlist, lockdown and
+// FailOpenBehavior for clientIP, which come before or after the block rules
+// depending on Precedence.
+func (m *Fail2BanMiddleware) checkAllowlist(add func(checkVerdict), clientIP string) {
+	add(checkVerdict{Check: "allowlist", Matched: m.allowlisted(clientIP)})
+	add(checkVerdict{Check: "lockdown", Matched: m.inLockdown(), Rule: ruleDefaultDeny})
+	add(checkVerdict{Check: "awaitingBlocklist", Matched: atomic.LoadUint32(&m.awaitingBlocklist) == 1, Rule: ruleDefaultDeny, Reason: "blocklist not loaded"})
+}
+
+// banVerdict returns the verdict of check for the ban b on key at now.
+func banVerdict(check, key string, b ban, now time.Time) checkVerdict {
+	v := checkVerdict{Check: check, Matched: true, Key: key, Rule: b.rule, Reason: b.reason}
+	if !b.expiry.IsZero() {
+		expiry := b.expiry.UTC()
+		v.ExpiresAt = &expiry
+		v.Expired = !now.Before(b.expiry)
+	}
+
+	return v
+}
+
+// liftedMatch reports whether matcher would have matched clientIP on one of
+// the blocklist entries in lifted, lifted for it through the admin API.
+func (m *Fail2BanMiddleware) liftedMatch(matcher ruleMatcher, clientIP string, lifted map[string]string) bool {
+	if len(lifted) == 0 {
+		return false
+	}
+
+	list := m.currentBlocklist()
+	switch matcher := matcher.(type) {
+	case exactMatcher:
+		_, listed := list.ips[clientIP]
+		_, ok := lifted[clientIP]
+		return !matcher.shadow && listed && ok
+	case cidrMatcher:
+		ipNet := list.matchNet(clientIP)
+		if matcher.shadow || ipNet == nil {
+			return false
+		}
+		_, ok := lifted[ipNet.String()]
+		return ok
+	}
+
+	return false
+}
+
+// matcherName returns the check name of a link of the matcher chain.
+func matcherName(matcher ruleMatcher) string {
+	switch matcher := matcher.(type) {
+	case exactMatcher:
+		return "blocklist"
+	case cidrMatcher:
+		return "blocklistCIDR"
+	case geoMatcher:
+		return "geo"
+	case asnMatcher:
+		return "asn"
+	case customMatcher:
+		return matcher.name
+	case ptrMatcher:
+		return "ptr"
+	}
+
+	return "matcher"
+}
+
+// failureCounts returns the failure windows of clientIP within findTime at
+// now, sorted by key. Failures let off by GracePeriodRequests count toward
+// Failures but not Score.
+func (m *Fail2BanMiddleware) failureCounts(clientIP string, now time.Time) []failureCount {
+	settings := m.settings()
+	if settings.maxRequests <= 0 {
+		return nil
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := []string{clientIP}
+	if m.trackByPath {
+		keys = keys[:0]
+		for key := range m.trackedKeys[clientIP] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+	}
+
+	var counts []failureCount
+	for _, key := range keys {
+		window, ok := m.requests[key]
+		if !ok {
+			continue
+		}
+		c := failureCount{Key: key, Threshold: settings.maxRequests}
+		c.Failures, _, c.Score = window.counts(now)
+		if window.paths != nil && now.Sub(window.paths.start) < settings.findTime {
+			c.DistinctPaths = window.paths.estimate()
+		}
+		if c.Failures > 0 {
+			counts = append(counts, c)
+		}
+	}
+
+	return counts
+}
+
+// rateTokens returns the tokens left in the bucket of clientIP at now, without
+// taking one.
+func (m *Fail2BanMiddleware) rateTokens(clientIP string, now time.Time) float64 {
+	burst := float64(m.rateBurst)
+
+	m.rateMu.Lock()
+	defer m.rateMu.Unlock()
+
+	bucket, ok := m.rateBuckets[clientIP]
+	if !ok {
+		return burst
+	}
+	if elapsed := now.Sub(bucket.updated); elapsed > 0 {
+		return math.Min(burst, bucket.tokens+elapsed.Seconds()*float64(m.rateLimit)/m.rateWindow.Seconds())
+	}
+
+	return bucket.tokens
+}
@@ -0,0 +1,190 @@
+package traefik_plugin
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// realIPHeader carries the client address set by some proxies instead of or
+// besides X-Forwarded-For.
+const realIPHeader = "X-Real-IP"
+
+// defaultMaxForwardedHops is how many forwarded hops are read when
+// MaxForwardedHops is unset.
+const defaultMaxForwardedHops = 10
+
+// clientIP returns the address the request should be attributed to. The
+// sources are tried in order, and the first carrying a usable address wins:
+//
+//  1. the connecting-IP header of the CDN provider whose edge the connection
+//     came from, as resolved by cdnClientIP;
+//  2. the forwarded header, as resolved by forwardedClientIP, when trusted;
+//  3. the X-Real-IP header, when trusted;
+//  4. the connection's remote address.
+//
+// With trusted proxies, the headers are only read from connections of a
+// trusted proxy. Clients connecting directly thus can't spoof their address:
+// for other connections the remote address wins outright. The same holds with
+// proxyProtocol and no trusted proxies, where the remote address is the one
+// from the PROXY protocol header. The address is normalized by normalizeIP, so
+// it matches the list keys.
+func (m *Fail2BanMiddleware) clientIP(req *http.Request) string {
+	if len(m.cdnEdges) > 0 {
+		if ip := m.cdnClientIP(req); ip != "" {
+			return normalizeIP(ip)
+		}
+	}
+
+	if m.proxyProtocol || len(m.trustedProxies) > 0 {
+		remote := hostFromAddr(req.RemoteAddr)
+		if ip := net.ParseIP(remote); ip == nil || !containsIP(m.trustedProxies, ip) {
+			return normalizeIP(remote)
+		}
+	}
+
+	if m.trustForwardHeader {
+		if ip := m.forwardedClientIP(req); ip != "" {
+			return normalizeIP(ip)
+		}
+	}
+
+	if m.trustRealIPHeader {
+		if ip := hostFromAddr(strings.TrimSpace(req.Header.Get(realIPHeader))); net.ParseIP(ip) != nil {
+			return normalizeIP(ip)
+		}
+	}
+
+	return normalizeIP(hostFromAddr(req.RemoteAddr))
+}
+
+// forwardedClientIP picks the client from the comma-separated forwarded header
+// of req, whose hops may carry a port as some proxies add one. Only the
+// right-most maxForwardedHops hops are read, so padding the header costs the
+// client more than the middleware. Without trusted proxies the left-most hop
+// read that is an IP wins, or "" if none is, so the caller falls back to the
+// remote address. With trusted proxies the hops are walked from the right,
+// skipping those inside a trusted range, so a client cannot spoof its address
+// by prepending hops; if every hop is trusted the left-most read is used.
+func (m *Fail2BanMiddleware) forwardedClientIP(req *http.Request) string {
+	hops := make([]string, 0, m.maxForwardedHops)
+	rest := req.Header.Get(m.forwardedHeaderName)
+	for rest != "" {
+		hop := rest
+		rest = ""
+		if i := strings.LastIndexByte(hop, ','); i >= 0 {
+			rest = hop[:i]
+			hop = hop[i+1:]
+		}
+		if hop = strings.TrimSpace(hop); hop == "" {
+			continue
+		}
+		if len(hops) == m.maxForwardedHops {
+			m.truncatedForwarded(req)
+			break
+		}
+		hops = append(hops, hostFromAddr(hop))
+	}
+	if len(hops) == 0 {
+		return ""
+	}
+
+	// The hops were collected from the right.
+	for i := 0; i < len(hops)/2; i++ {
+		j := len(hops) - 1 - i
+		hop := hops[i]
+		hops[i] = hops[j]
+		hops[j] = hop
+	}
+
+	if len(m.trustedProxies) == 0 {
+		for _, hop := range hops {
+			if net.ParseIP(hop) != nil {
+				return hop
+			}
+		}
+		return ""
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(hops[i])
+		if ip == nil || !containsIP(m.trustedProxies, ip) {
+			return hops[i]
+		}
+	}
+
+	return hops[0]
+}
+
+// truncatedForwarded logs a forwarded header of req cut to maxForwardedHops,
+// as a warning the first time and at debug level afterwards, since clients
+// can send as many as they like.
+func (m *Fail2BanMiddleware) truncatedForwarded(req *http.Request) {
+	if atomic.CompareAndSwapUint32(&m.forwardedWarned, 0, 1) {
+		m.logger.Warn("Truncated oversized forwarded header; further ones are logged at debug level", "header", m.forwardedHeaderName, "maxHops", m.maxForwardedHops, "remoteAddr", req.RemoteAddr, "path", req.URL.Path)
+		return
+	}
+
+	m.logger.Debug("Truncated oversized forwarded header", "header", m.forwardedHeaderName, "maxHops", m.maxForwardedHops, "remoteAddr", req.RemoteAddr, "path", req.URL.Path)
+}
+
+// hostFromAddr strips the port from a host:port address such as
+// "1.2.3.4:443" or "[2001:db8::1]:443". Addresses without a port, including
+// bare IPv6 addresses, are returned unchanged apart from any brackets.
+func hostFromAddr(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	}
+
+	return host
+}
+
+// normalizeIP returns the canonical form of an IP address, so that for
+// example ::ffff:1.2.3.4 and 1.2.3.4, or differently cased or abbreviated IPv6
+// addresses, map to the same key. Strings that aren't an IP are returned
+// unchanged.
+func normalizeIP(s string) string {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return s
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String()
+	}
+
+	return ip.String()
+}
+
+// normalizeCIDR turns an IPv4-mapped IPv6 range such as ::ffff:10.0.0.0/104
+// into the IPv4 range it covers, so it matches the normalized IPv4 addresses.
+// Other ranges are returned unchanged.
+func normalizeCIDR(ipNet *net.IPNet) *net.IPNet {
+	ones, bits := ipNet.Mask.Size()
+	ip4 := ipNet.IP.To4()
+	if bits != 8*net.IPv6len || ip4 == nil || ones < 96 {
+		return ipNet
+	}
+
+	return &net.IPNet{IP: ip4, Mask: net.CIDRMask(ones-96, 8*net.IPv4len)}
+}
+
+// containsIP reports whether ip falls within one of nets.
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isPrivateIP reports whether clientIP is a loopback address or in a private
+// range, such as 10.0.0.0/8 or fc00::/7. Such a client address usually means
+// the real client wasn't resolved and the request came from an internal hop.
+func isPrivateIP(clientIP string) bool {
+	ip := net.ParseIP(clientIP)
+	return ip != nil && (ip.IsLoopback() || ip.IsPrivate())
+}
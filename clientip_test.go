@@ -0,0 +1,234 @@
+package traefik_plugin
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// newRequest returns a GET request for / from remoteAddr, with headers.
+func newRequest(remoteAddr string, headers map[string]string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = remoteAddr
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	return req
+}
+
+func TestClientIPTrustedProxies(t *testing.T) {
+	m := newTestMiddleware(t, func(c *Config) {
+		c.TrustForwardHeader = true
+		c.TrustRealIPHeader = true
+		c.TrustedProxies = []string{"10.0.0.0/8"}
+	})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{
+			name:       "forwarded by a trusted proxy",
+			remoteAddr: "10.0.0.1:4321",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.7"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "forwarded through trusted proxies",
+			remoteAddr: "10.0.0.1:4321",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.7, 10.0.0.2"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "hop prepended by the client",
+			remoteAddr: "10.0.0.1:4321",
+			headers:    map[string]string{"X-Forwarded-For": "192.0.2.99, 203.0.113.7"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "real IP from a trusted proxy",
+			remoteAddr: "10.0.0.1:4321",
+			headers:    map[string]string{"X-Real-IP": "203.0.113.7"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "trusted proxy without headers",
+			remoteAddr: "10.0.0.1:4321",
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "forwarded by an untrusted peer",
+			remoteAddr: "198.18.0.1:4321",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.7"},
+			want:       "198.18.0.1",
+		},
+		{
+			name:       "real IP from an untrusted peer",
+			remoteAddr: "198.18.0.1:4321",
+			headers:    map[string]string{"X-Real-IP": "203.0.113.7"},
+			want:       "198.18.0.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := m.clientIP(newRequest(tt.remoteAddr, tt.headers)); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostFromAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"192.0.2.1:443", "192.0.2.1"},
+		{"192.0.2.1", "192.0.2.1"},
+		{"[2001:db8::1]:443", "2001:db8::1"},
+		{"[2001:db8::1]", "2001:db8::1"},
+		{"2001:db8::1", "2001:db8::1"},
+		{"::1", "::1"},
+	}
+	for _, tt := range tests {
+		if got := hostFromAddr(tt.addr); got != tt.want {
+			t.Errorf("hostFromAddr(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
+
+// TestNormalizeIPMapped checks that IPv4-mapped IPv6 addresses map to the key
+// of their IPv4 address, so listed IPv4 addresses are blocked over IPv6 too.
+func TestNormalizeIPMapped(t *testing.T) {
+	for _, ip := range []string{"::ffff:192.0.2.1", "::FFFF:192.0.2.1", "0:0:0:0:0:ffff:c000:0201"} {
+		if got := normalizeIP(ip); got != "192.0.2.1" {
+			t.Errorf("normalizeIP(%q) = %q, want %q", ip, got, "192.0.2.1")
+		}
+	}
+
+	m := newTestMiddleware(t, nil)
+	if rec := serve(m, "[::ffff:192.0.2.1]:4321", "/", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("mapped address of a listed IP: status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+func TestNormalizeIPv6(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"2001:0db8:0000:0000:0000:0000:0000:0001", "2001:db8::1"},
+		{"2001:DB8::1", "2001:db8::1"},
+		{"2001:db8:0:0:1:0:0:1", "2001:db8::1:0:0:1"},
+		{"0:0:0:0:0:0:0:1", "::1"},
+		{"fe80::1%eth0", "fe80::1%eth0"}, // not an IP, returned unchanged
+	}
+	for _, tt := range tests {
+		if got := normalizeIP(tt.ip); got != tt.want {
+			t.Errorf("normalizeIP(%q) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+
+	cidrs := []struct {
+		cidr string
+		want string
+	}{
+		{"2001:0DB8:00FF::/48", "2001:db8:ff::/48"},
+		{"::ffff:10.0.0.0/104", "10.0.0.0/8"},
+		{"::ffff:192.0.2.1/128", "192.0.2.1/32"},
+	}
+	for _, tt := range cidrs {
+		_, ipNet, err := net.ParseCIDR(tt.cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := normalizeCIDR(ipNet).String(); got != tt.want {
+			t.Errorf("normalizeCIDR(%q) = %q, want %q", tt.cidr, got, tt.want)
+		}
+	}
+}
+
+// TestIPv6BlocklistKeys checks that IPv6 blocklist entries match request
+// addresses written differently.
+func TestIPv6BlocklistKeys(t *testing.T) {
+	m := newTestMiddleware(t, func(c *Config) {
+		list := "2001:0DB8:0000:0000:0000:0000:0000:0001\n2001:0db8:00ff::/48\n"
+		if err := os.WriteFile(c.BlocklistPath, []byte(list), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	tests := []struct {
+		remoteAddr string
+		wantCode   int
+	}{
+		{"[2001:db8::1]:4321", http.StatusForbidden},
+		{"[2001:DB8:0::1]:4321", http.StatusForbidden},
+		{"[2001:db8:ff:0:0:0:0:abcd]:4321", http.StatusForbidden},
+		{"[2001:db8::2]:4321", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if rec := serve(m, tt.remoteAddr, "/", nil); rec.Code != tt.wantCode {
+			t.Errorf("%s: status = %d, want %d", tt.remoteAddr, rec.Code, tt.wantCode)
+		}
+	}
+}
+
+// TestUnparseableClientIP checks that an empty or garbage remote address is
+// never used as a key: such requests pass unchecked, and are never counted
+// towards a ban, unless DenyUnparseable rejects them.
+func TestUnparseableClientIP(t *testing.T) {
+	m := newTestMiddleware(t, func(c *Config) { c.MaxRequests = 1 })
+
+	for _, remoteAddr := range []string{"", "garbage", "garbage:4321", "[]:4321"} {
+		if got := m.clientIP(newRequest(remoteAddr, nil)); net.ParseIP(got) != nil {
+			t.Errorf("clientIP() from %q = %q, want no IP", remoteAddr, got)
+		}
+		for i := 0; i < 3; i++ {
+			if rec := serve(m, remoteAddr, "/fail", nil); rec.Code != http.StatusUnauthorized {
+				t.Errorf("request from %q: status = %d, want the next handler's %d", remoteAddr, rec.Code, http.StatusUnauthorized)
+			}
+		}
+	}
+
+	m.mu.Lock()
+	bans := m.bans.List()
+	m.mu.Unlock()
+	if len(bans) != 0 {
+		t.Errorf("unparseable addresses were banned: %v", bans)
+	}
+
+	deny := newTestMiddleware(t, func(c *Config) { c.DenyUnparseable = true })
+	if rec := serve(deny, "", "/", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("DenyUnparseable: status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+// TestForwardedClientIPUnparseable checks that without trusted proxies the
+// left-most forwarded hop that is an IP is used, and the remote address when
+// none is.
+func TestForwardedClientIPUnparseable(t *testing.T) {
+	m := newTestMiddleware(t, func(c *Config) { c.TrustForwardHeader = true })
+
+	tests := []struct {
+		forwarded string
+		want      string
+	}{
+		{"203.0.113.7, 198.18.0.2", "203.0.113.7"},
+		{"unknown, 203.0.113.7", "203.0.113.7"},
+		{"garbage, [2001:db8::1]:443, 198.18.0.2", "2001:db8::1"},
+		{"unknown", "198.18.0.1"},
+		{"garbage, , junk", "198.18.0.1"},
+	}
+	for _, tt := range tests {
+		req := newRequest("198.18.0.1:4321", map[string]string{"X-Forwarded-For": tt.forwarded})
+		if got := m.clientIP(req); got != tt.want {
+			t.Errorf("clientIP() with %q forwarded = %q, want %q", tt.forwarded, got, tt.want)
+		}
+	}
+}
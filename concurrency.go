@@ -0,0 +1,45 @@
+package traefik_plugin
+
+import "net/http"
+
+// acquireSlot takes one of the MaxConcurrentPerIP slots of clientIP for a
+// request, reporting false when they are all in use. Each slot taken must be
+// returned with releaseSlot.
+func (m *Fail2BanMiddleware) acquireSlot(clientIP string) bool {
+	m.concurrentMu.Lock()
+	defer m.concurrentMu.Unlock()
+
+	if m.concurrent[clientIP] >= m.maxConcurrent {
+		return false
+	}
+	m.concurrent[clientIP]++
+
+	return true
+}
+
+// releaseSlot returns a slot of clientIP taken by acquireSlot. IPs without
+// requests in flight are forgotten, so the map only holds active clients.
+func (m *Fail2BanMiddleware) releaseSlot(clientIP string) {
+	m.concurrentMu.Lock()
+	defer m.concurrentMu.Unlock()
+
+	if m.concurrent[clientIP] <= 1 {
+		delete(m.concurrent, clientIP)
+		return
+	}
+	m.concurrent[clientIP]--
+}
+
+// inFlight returns the number of requests of clientIP in flight.
+func (m *Fail2BanMiddleware) inFlight(clientIP string) int {
+	m.concurrentMu.Lock()
+	defer m.concurrentMu.Unlock()
+
+	return m.concurrent[clientIP]
+}
+
+// tooManyConcurrent writes the response for a request beyond
+// MaxConcurrentPerIP, a 429 unless RuleStatusCodes says otherwise.
+func (m *Fail2BanMiddleware) tooManyConcurrent(rw http.ResponseWriter, clientIP string) {
+	m.writeBlockResponse(rw, m.ruleStatusCodes[ruleConcurrency], clientIP, "too_many_concurrent_requests")
+}
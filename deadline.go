@@ -0,0 +1,51 @@
+package traefik_plugin
+
+import (
+	"net/http"
+	"time"
+)
+
+// suspicionScore returns the failure score of clientIP within findTime at now,
+// summed over its paths with trackByPath.
+func (m *Fail2BanMiddleware) suspicionScore(clientIP string, now time.Time) int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := []string{clientIP}
+	if m.trackByPath {
+		keys = keys[:0]
+		for key := range m.trackedKeys[clientIP] {
+			keys = append(keys, key)
+		}
+	}
+
+	score := 0
+	for _, key := range keys {
+		window, ok := m.requests[key]
+		if !ok {
+			continue
+		}
+		_, _, s := window.counts(now)
+		score += s
+	}
+
+	return score
+}
+
+// limitSuspicious shortens the read and write deadlines of the connection
+// serving a request from a suspicious clientIP to suspiciousTimeout from now,
+// so a slow client can't tie up the handler for long. Writers that don't
+// support deadlines are left alone.
+func (m *Fail2BanMiddleware) limitSuspicious(rw http.ResponseWriter, clientIP string, now time.Time) {
+	deadline := now.Add(m.suspiciousTimeout)
+	rc := http.NewResponseController(rw)
+	if err := rc.SetReadDeadline(deadline); err != nil {
+		m.logger.Debug("Cannot limit processing time of suspicious request", "ip", m.logIP(clientIP), "error", err)
+		return
+	}
+	_ = rc.SetWriteDeadline(deadline)
+
+	if m.verbose {
+		m.logger.Info("Limited processing time of suspicious request", "ip", m.logIP(clientIP), "timeout", m.suspiciousTimeout)
+	}
+}
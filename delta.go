@@ -0,0 +1,131 @@
+package traefik_plugin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// reloadDelta is a parsed MaxReloadDelta: a number of entries, or a
+// percentage of the loaded blocklist when percent is set.
+type reloadDelta struct {
+	limit   float64
+	percent bool
+}
+
+// parseReloadDelta parses MaxReloadDelta, such as "1000" or "10%". The empty
+// string returns nil, which disables the check.
+func parseReloadDelta(s string) (*reloadDelta, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+
+	d := &reloadDelta{}
+	if trimmed := strings.TrimSuffix(s, "%"); trimmed != s {
+		d.percent = true
+		s = strings.TrimSpace(trimmed)
+	}
+
+	limit, err := strconv.ParseFloat(s, 64)
+	if err != nil || limit < 0 {
+		return nil, errors.New("must be a count or a percentage such as 10%")
+	}
+	if !d.percent && limit != float64(int(limit)) {
+		return nil, errors.New("count must be a whole number")
+	}
+	d.limit = limit
+
+	return d, nil
+}
+
+// check returns an error if a reload adding and removing the given numbers of
+// entries to a list of size entries changes more than the delta allows. An
+// empty list, as on startup, accepts any change.
+func (d *reloadDelta) check(size, added, removed int) error {
+	if d == nil || size == 0 {
+		return nil
+	}
+
+	limit := d.limit
+	if d.percent {
+		limit = d.limit * float64(size) / 100
+	}
+
+	if float64(added) > limit || float64(removed) > limit {
+		return fmt.Errorf("reload would add %d and remove %d of %d entries, over maxReloadDelta %s; force it with POST /reload?force=true", added, removed, size, d.String())
+	}
+
+	return nil
+}
+
+func (d *reloadDelta) String() string {
+	if d.percent {
+		return strconv.FormatFloat(d.limit, 'f', -1, 64) + "%"
+	}
+	return strconv.FormatFloat(d.limit, 'f', -1, 64)
+}
+
+// listDelta returns how many IPs and CIDRs next has that current hasn't, and
+// the other way around.
+func listDelta(current, next *ipList) (added, removed int) {
+	before := listEntries(current)
+	after := listEntries(next)
+
+	for entry := range after {
+		if _, ok := before[entry]; !ok {
+			added++
+		}
+	}
+	for entry := range before {
+		if _, ok := after[entry]; !ok {
+			removed++
+		}
+	}
+
+	return added, removed
+}
+
+// listEntries returns the IPs and CIDRs of list.
+func listEntries(list *ipList) map[string]struct{} {
+	entries := make(map[string]struct{}, len(list.ips)+len(list.nets))
+	for ip := range list.ips {
+		entries[ip] = struct{}{}
+	}
+	for _, ipNet := range list.nets {
+		entries[ipNet.String()] = struct{}{}
+	}
+
+	return entries
+}
+
+// handleReload reloads the lists at once. With force=true in the query the
+// blocklist is swapped in even if it changes more than MaxReloadDelta allows,
+// for intentional large changes. It responds with the blocklist's stats, or
+// the reload errors.
+func (m *Fail2BanMiddleware) handleReload(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeMethodNotAllowed(rw, http.MethodPost)
+		return
+	}
+
+	force := req.URL.Query().Get("force") == "true"
+	if force {
+		m.logger.Warn("Forcing blocklist reload past maxReloadDelta")
+	}
+
+	blockErr := m.reloadBlocklistForce(force)
+	allowErr := m.reloadAllowlist()
+	if m.blockBodyFile != "" {
+		m.loadBlockBody()
+	}
+	m.logReloadErrors(blockErr, allowErr)
+	if err := errors.Join(blockErr, allowErr); err != nil {
+		writeJSONError(rw, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, m.BlocklistStats())
+}
@@ -0,0 +1,71 @@
+package traefik_plugin
+
+import (
+	"net/http"
+	"sync"
+)
+
+// eventLog is a fixed-size ring of the most recent ban and block events,
+// served by GET /events for forensics without a logging pipeline.
+type eventLog struct {
+	anonymize bool // of the events' IPs, with AnonymizeLoggedIPs
+
+	mu     sync.Mutex
+	events []banEvent
+	next   int  // index the next event is written to
+	full   bool // whether the ring has wrapped
+}
+
+// newEventLog returns a log keeping the last size events, or nil if size is
+// zero.
+func newEventLog(size int, anonymize bool) *eventLog {
+	if size <= 0 {
+		return nil
+	}
+
+	return &eventLog{anonymize: anonymize, events: make([]banEvent, size)}
+}
+
+// add records event, overwriting the oldest one when full.
+func (l *eventLog) add(event banEvent) {
+	if l.anonymize {
+		event.IP = anonymizeIP(event.IP)
+	}
+
+	l.mu.Lock()
+	l.events[l.next] = event
+	l.next++
+	if l.next == len(l.events) {
+		l.next = 0
+		l.full = true
+	}
+	l.mu.Unlock()
+}
+
+// list returns the recorded events, oldest first.
+func (l *eventLog) list() []banEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.full {
+		return append([]banEvent{}, l.events[:l.next]...)
+	}
+	events := make([]banEvent, 0, len(l.events))
+	events = append(events, l.events[l.next:]...)
+
+	return append(events, l.events[:l.next]...)
+}
+
+// handleEvents lists the most recent ban and block events, oldest first.
+func (m *Fail2BanMiddleware) handleEvents(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeMethodNotAllowed(rw, http.MethodGet)
+		return
+	}
+
+	events := []banEvent{}
+	if m.events != nil {
+		events = m.events.list()
+	}
+	writeJSON(rw, http.StatusOK, events)
+}
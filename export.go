@@ -0,0 +1,236 @@
+package traefik_plugin
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Default sets of the ipset export format.
+const (
+	defaultIPSetName  = "fail2ban"
+	defaultIPSetName6 = "fail2ban6"
+)
+
+// maxSetNameLen is the longest set name ipset accepts.
+const maxSetNameLen = 31
+
+// validSetName reports whether name is usable as an ipset set name in restore
+// commands. Empty selects the default.
+func validSetName(name string) bool {
+	return len(name) <= maxSetNameLen && strings.IndexFunc(name, unicode.IsSpace) < 0
+}
+
+// exportEntries returns the blocked IPs and CIDRs in effect at now: the exact
+// blocklist entries not lifted through the admin API, then the blocked CIDR
+// ranges, both in the order they are listed, then the exclusions carved out of
+// them, then the local dynamic bans not already listed, unless they are
+// hashed with HashStoredIPs. Shared bans held only in Redis are not included.
+func (m *Fail2BanMiddleware) exportEntries(now time.Time) []banEntry {
+	list := m.currentBlocklist()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	bans := m.bans.List()
+	entries := make([]banEntry, 0, len(list.ips)+len(list.nets)+len(bans))
+	for _, ip := range list.order {
+		if _, ok := list.ips[ip]; !ok {
+			continue
+		}
+		if u, ok := m.unbanned[ip]; ok && u.active(now) {
+			if _, ok := u.entries[ip]; ok {
+				continue
+			}
+		}
+		if list.expired(ip, now) {
+			continue
+		}
+		rule := ruleExact
+		if _, ok := list.hosts[ip]; ok {
+			rule = ruleHost
+		}
+		entries = append(entries, banEntry{IP: ip, Rule: rule, Reason: list.reasons[ip], ExpiresAt: listExpiry(list, ip)})
+	}
+
+	for _, ipNet := range list.nets {
+		key := ipNet.String()
+		if list.expired(key, now) {
+			continue
+		}
+		entries = append(entries, banEntry{IP: key, Rule: ruleCIDR, Reason: list.reasons[key], ExpiresAt: listExpiry(list, key)})
+	}
+	if list.excluded != nil {
+		var excluded []banEntry
+		for ip := range list.excluded.ips {
+			excluded = append(excluded, banEntry{IP: ip, Rule: ruleExclude})
+		}
+		for _, ipNet := range list.excluded.nets {
+			excluded = append(excluded, banEntry{IP: ipNet.String(), Rule: ruleExclude})
+		}
+		sort.Slice(excluded, func(i, j int) bool { return excluded[i].IP < excluded[j].IP })
+		entries = append(entries, excluded...)
+	}
+
+	start := len(entries)
+	for ip, b := range bans {
+		if _, listed := list.ips[ip]; listed || isHashedKey(ip) {
+			continue
+		}
+		if b.expiry.IsZero() || now.Before(b.expiry) {
+			entries = append(entries, newBanEntry(ip, b))
+		}
+	}
+	dynamic := entries[start:]
+	sort.Slice(dynamic, func(i, j int) bool { return dynamic[i].IP < dynamic[j].IP })
+
+	return entries
+}
+
+// listExpiry returns the expiry of the list entry, or nil if it is permanent.
+func listExpiry(list *ipList, entry string) *time.Time {
+	expiry, ok := list.expiries[entry]
+	if !ok {
+		return nil
+	}
+	return &expiry
+}
+
+// handleExport writes the effective blocklist. By default it is written in the
+// blocklist file format, one entry per line with its expiry and reason as
+// annotations, so it can be loaded back as a blocklist; with ?format=json it
+// is written as JSON entries including rules and expiries. ?format=ipset
+// writes ipset restore commands and ?format=cidr plain CIDRs, for enforcing
+// the blocklist in the kernel as well.
+func (m *Fail2BanMiddleware) handleExport(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeMethodNotAllowed(rw, http.MethodGet)
+		return
+	}
+
+	entries := m.exportEntries(m.nowFunc())
+
+	switch format := req.URL.Query().Get("format"); format {
+	case "json":
+		writeJSON(rw, http.StatusOK, entries)
+	case "", "text":
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		rw.Header().Set("Content-Disposition", `attachment; filename="blocklist.txt"`)
+		w := bufio.NewWriter(rw)
+		for _, entry := range entries {
+			if entry.Rule == ruleExclude {
+				w.WriteByte('!')
+			}
+			w.WriteString(entry.IP)
+			if entry.ExpiresAt != nil {
+				w.WriteString(" expires=")
+				w.WriteString(entry.ExpiresAt.UTC().Format(time.RFC3339))
+			}
+			if entry.Reason != "" {
+				w.WriteString(" # ")
+				w.WriteString(entry.Reason)
+			}
+			w.WriteByte('\n')
+		}
+		_ = w.Flush()
+	case "ipset":
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w := bufio.NewWriter(rw)
+		m.writeIPSet(w, entries)
+		_ = w.Flush()
+	case "cidr":
+		rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w := bufio.NewWriter(rw)
+		for _, ipNet := range exportNets(entries) {
+			w.WriteString(ipNet.String())
+			w.WriteByte('\n')
+		}
+		_ = w.Flush()
+	default:
+		writeJSONError(rw, http.StatusBadRequest, fmt.Sprintf("unsupported format %q", format))
+	}
+}
+
+// writeIPSet writes entries as "add" commands for ipset restore: IPv4 entries
+// to the hash:net set ipsetName and IPv6 ones to ipsetName6. Exclusions are
+// added with the nomatch option, which hash:net sets honor for addresses
+// inside a broader entry.
+func (m *Fail2BanMiddleware) writeIPSet(w *bufio.Writer, entries []banEntry) {
+	for _, entry := range entries {
+		ipNet := entryNet(entry.IP)
+		if ipNet == nil {
+			continue
+		}
+
+		set := m.ipsetName
+		if ipNet.IP.To4() == nil {
+			set = m.ipsetName6
+		}
+		w.WriteString("add ")
+		w.WriteString(set)
+		w.WriteByte(' ')
+		w.WriteString(entry.IP)
+		if entry.Rule == ruleExclude {
+			w.WriteString(" nomatch")
+		}
+		w.WriteByte('\n')
+	}
+}
+
+// exportNets returns the blocked entries as CIDRs, exact IPs as /32 or /128,
+// with the exclusions subtracted from the ranges they carve into. As with the
+// blocklist, exclusions don't apply to exact entries.
+func exportNets(entries []banEntry) []*net.IPNet {
+	var excluded []*net.IPNet
+	for _, entry := range entries {
+		if entry.Rule == ruleExclude {
+			if ipNet := entryNet(entry.IP); ipNet != nil {
+				excluded = append(excluded, ipNet)
+			}
+		}
+	}
+
+	var nets []*net.IPNet
+	for _, entry := range entries {
+		ipNet := entryNet(entry.IP)
+		if ipNet == nil || entry.Rule == ruleExclude {
+			continue
+		}
+		ones, bits := ipNet.Mask.Size()
+		if ones == bits {
+			nets = append(nets, ipNet)
+		} else {
+			nets = append(nets, subtractNets(ipNet, excluded)...)
+		}
+	}
+
+	return nets
+}
+
+// entryNet returns an exported IP or CIDR as a CIDR, or nil if it is neither.
+func entryNet(entry string) *net.IPNet {
+	if strings.Contains(entry, "/") {
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil
+		}
+		return ipNet
+	}
+
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return nil
+	}
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		bits = 8 * net.IPv4len
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+}
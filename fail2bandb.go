@@ -0,0 +1,159 @@
+package traefik_plugin
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ruleImported is reported for bans imported from a fail2ban database.
+const ruleImported = "imported"
+
+// fail2banBan is a ban read from a fail2ban database.
+type fail2banBan struct {
+	jail   string
+	expiry time.Time // zero means permanent
+}
+
+// importResult is the response of POST /import-fail2ban.
+type importResult struct {
+	Imported int `json:"imported"`
+	// Skipped counts the IPs that already had a dynamic ban, which is kept.
+	Skipped int `json:"skipped"`
+}
+
+// readFail2BanDB returns the bans of the fail2ban database at path still in
+// effect at now, by IP, keeping the longest of an IP's bans across jails. The
+// bips table of fail2ban 0.11 and later is read when it exists, the bans
+// table otherwise. Bans without a bantime, as recorded by versions before
+// 0.11, last defaultBanTime from when they were made; a negative bantime
+// bans for good.
+func readFail2BanDB(path string, now time.Time, defaultBanTime time.Duration) (map[string]fail2banBan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	db, err := openSQLite(data)
+	if err != nil {
+		return nil, err
+	}
+
+	root, columns, err := db.table("bips")
+	if err == nil && root == 0 {
+		root, columns, err = db.table("bans")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if root == 0 {
+		return nil, errors.New("no bips or bans table")
+	}
+
+	index := make(map[string]int, len(columns))
+	for i, column := range columns {
+		index[column] = i
+	}
+	if _, ok := index["ip"]; !ok {
+		return nil, errors.New("ban table has no ip column")
+	}
+	if _, ok := index["timeofban"]; !ok {
+		return nil, errors.New("ban table has no timeofban column")
+	}
+
+	// value returns the named column of a row, or nil if the table or the
+	// row lacks it.
+	value := func(values []interface{}, column string) interface{} {
+		i, ok := index[column]
+		if !ok || i >= len(values) {
+			return nil
+		}
+		return values[i]
+	}
+
+	bans := make(map[string]fail2banBan)
+	err = db.scan(root, func(values []interface{}) error {
+		raw, _ := value(values, "ip").(string)
+		ip := net.ParseIP(raw)
+		if ip == nil {
+			return nil
+		}
+		timeOfBan, ok := value(values, "timeofban").(int64)
+		if !ok {
+			return nil
+		}
+		jail, _ := value(values, "jail").(string)
+
+		b := fail2banBan{jail: jail}
+		if banTime, ok := value(values, "bantime").(int64); !ok {
+			b.expiry = time.Unix(timeOfBan, 0).Add(defaultBanTime)
+		} else if banTime >= 0 {
+			b.expiry = time.Unix(timeOfBan+banTime, 0)
+		}
+		if !b.expiry.IsZero() && !now.Before(b.expiry) {
+			return nil
+		}
+
+		key := normalizeIP(ip.String())
+		if current, ok := bans[key]; ok && (current.expiry.IsZero() || (!b.expiry.IsZero() && !b.expiry.After(current.expiry))) {
+			return nil
+		}
+		bans[key] = b
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return bans, nil
+}
+
+// importFail2BanDB seeds the dynamic bans with those of the fail2ban database
+// at Fail2BanDBPath, for their remaining time. IPs already banned keep their
+// ban.
+func (m *Fail2BanMiddleware) importFail2BanDB() (importResult, error) {
+	now := m.nowFunc()
+	bans, err := readFail2BanDB(m.fail2banDBPath, now, m.settings().banTime)
+	if err != nil {
+		return importResult{}, err
+	}
+
+	var result importResult
+	m.mu.Lock()
+	for ip, fb := range bans {
+		if _, ok := m.bans.Get(ip); ok {
+			result.Skipped++
+			continue
+		}
+		m.bans.Set(ip, ban{rule: ruleImported, reason: fb.jail, expiry: fb.expiry, hits: &banHits{}})
+		result.Imported++
+	}
+	m.mu.Unlock()
+	m.cache.purge()
+
+	m.logger.Info("Imported bans from fail2ban database", "path", m.fail2banDBPath, "imported", result.Imported, "skipped", result.Skipped)
+
+	return result, nil
+}
+
+// handleImportFail2Ban imports the fail2ban database at Fail2BanDBPath again,
+// to pick up bans fail2ban made since startup while migrating.
+func (m *Fail2BanMiddleware) handleImportFail2Ban(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeMethodNotAllowed(rw, http.MethodPost)
+		return
+	}
+	if m.fail2banDBPath == "" {
+		writeJSONError(rw, http.StatusNotFound, "no fail2ban database configured")
+		return
+	}
+
+	result, err := m.importFail2BanDB()
+	if err != nil {
+		writeJSONError(rw, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, result)
+}
@@ -0,0 +1,246 @@
+package traefik_plugin
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultFetchTimeout bounds a single fetch of a remote blocklist when
+	// FetchTimeout is unset.
+	defaultFetchTimeout = 10 * time.Second
+
+	// defaultMaxBlocklistBytes caps the size of a remote blocklist when
+	// MaxBlocklistBytes is unset.
+	defaultMaxBlocklistBytes = 32 << 20
+
+	// defaultStreamThreshold is the file size above which lists are parsed
+	// while reading when StreamThreshold is unset.
+	defaultStreamThreshold = 4 << 20
+)
+
+// gzipMagic starts gzipped blocklists, which are decompressed whatever their
+// name or content type.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// envSourcePrefix marks the blocklist path of BlocklistEnv, followed by the
+// name of the environment variable.
+const envSourcePrefix = "env:"
+
+// blocklistSource is the last successfully loaded content of one blocklist
+// path or URL, along with the HTTP validators of its last response.
+type blocklistSource struct {
+	etag         string
+	lastModified string
+	list         *ipList
+}
+
+// isURL reports whether path refers to a remote list rather than a file.
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// isFile reports whether path refers to a blocklist file, as opposed to a URL
+// or an environment variable.
+func isFile(path string) bool {
+	return !isURL(path) && !strings.HasPrefix(path, envSourcePrefix)
+}
+
+// loadSource loads and parses the blocklist at path, which is a file, a URL or
+// an environment variable. For URLs it returns a nil list and no error when the
+// server reports the list unchanged. The caller must hold m.reloadMu.
+func (m *Fail2BanMiddleware) loadSource(path string, src *blocklistSource) (*ipList, error) {
+	var data []byte
+	switch {
+	case strings.HasPrefix(path, envSourcePrefix):
+		name := strings.TrimPrefix(path, envSourcePrefix)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %s is not set", name)
+		}
+		data = []byte(value)
+	case isURL(path):
+		var err error
+		data, err = m.fetchBlocklist(path, src)
+		if err != nil || data == nil {
+			return nil, err
+		}
+	default:
+		list, err := m.parseFile(path, m.parseBlocklist, true)
+		if err != nil {
+			return nil, err
+		}
+		return &list, nil
+	}
+
+	list, err := m.parseBlocklist(bytes.NewReader(data), path, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &list, nil
+}
+
+// parseFile parses the list file at path with parse, decompressing it if it is
+// gzipped. Files up to streamThreshold bytes are read in one go; larger ones
+// are parsed while reading so they are never held in memory as a whole.
+func (m *Fail2BanMiddleware) parseFile(path string, parse func(io.Reader, string, bool) (ipList, error), resolveHosts bool) (ipList, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ipList{}, err
+	}
+
+	var r io.Reader
+	if info.Size() <= m.streamThreshold {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return ipList{}, err
+		}
+		r = bytes.NewReader(data)
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return ipList{}, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(len(gzipMagic)); !bytes.Equal(magic, gzipMagic) {
+		return parse(br, path, resolveHosts)
+	}
+
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		return ipList{}, err
+	}
+	defer zr.Close()
+
+	return parse(zr, path, resolveHosts)
+}
+
+// newFetchClient returns the client blocklist URLs are fetched with, going
+// through the proxy at proxy when set and through the proxy of the
+// environment otherwise.
+func newFetchClient(proxy string) (*http.Client, error) {
+	if proxy == "" {
+		return &http.Client{}, nil
+	}
+
+	// The parse error would repeat the URL along with its credentials.
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		return nil, errors.New("malformed URL")
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q", proxyURL.Scheme)
+	}
+	if proxyURL.Host == "" {
+		return nil, errors.New("missing host")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyURL(proxyURL)
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// gunzip decompresses data if it is gzipped, failing if the result exceeds
+// limit bytes, and returns other data as is.
+func gunzip(data []byte, limit int64) ([]byte, error) {
+	if !bytes.HasPrefix(data, gzipMagic) {
+		return data, nil
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	out, err := io.ReadAll(io.LimitReader(zr, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > limit {
+		return nil, fmt.Errorf("decompressed blocklist exceeds %d bytes", limit)
+	}
+
+	return out, nil
+}
+
+// reloadContext returns m.ctx, or a background context while New is still
+// loading the lists and the middleware's context doesn't exist yet.
+func (m *Fail2BanMiddleware) reloadContext() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+	return m.ctx
+}
+
+// fetchBlocklist downloads the blocklist from url, sending the validators of
+// the previous response so an unchanged list costs a 304 and no re-parse. A
+// gzipped list is decompressed. The fetch fails after fetchTimeout and for
+// bodies over maxBlocklistBytes, compressed or not. The caller must hold
+// m.reloadMu.
+func (m *Fail2BanMiddleware) fetchBlocklist(url string, src *blocklistSource) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(m.reloadContext(), m.fetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if src.list != nil {
+		if src.etag != "" {
+			req.Header.Set("If-None-Match", src.etag)
+		}
+		if src.lastModified != "" {
+			req.Header.Set("If-Modified-Since", src.lastModified)
+		}
+	}
+
+	resp, err := m.fetchClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, nil
+	case http.StatusOK:
+	default:
+		return nil, fmt.Errorf("unexpected status fetching %s: %s", url, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, m.maxBlocklistBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > m.maxBlocklistBytes {
+		return nil, fmt.Errorf("blocklist exceeds %d bytes", m.maxBlocklistBytes)
+	}
+	data, err = gunzip(data, m.maxBlocklistBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	src.etag = resp.Header.Get("ETag")
+	src.lastModified = resp.Header.Get("Last-Modified")
+
+	return data, nil
+}
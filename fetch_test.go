@@ -0,0 +1,155 @@
+package traefik_plugin
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testLargeBlocklist returns a blocklist mixing every kind of line, long
+// enough to span many reads when streamed.
+func testLargeBlocklist() string {
+	var b strings.Builder
+	b.WriteString("# mode: deny\n")
+	b.WriteString("192.0.2.1 # scanner\r\n")
+	b.WriteString("192.0.2.2 expires=2099-01-01T00:00:00Z # until later\n")
+	b.WriteString("198.51.100.0/24\n")
+	b.WriteString("!198.51.100.7\n")
+	b.WriteString("2001:DB8::1\n")
+	b.WriteString("2001:db8:ff::/48 # v6 range\n")
+	b.WriteString("203.0.113.*\n")
+	b.WriteString("192.0.2.1 # duplicate\n")
+	b.WriteString("not-an-ip\n")
+	b.WriteString("   \n")
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&b, "10.%d.%d.1 # generated entry %d\n", i/256, i%256, i)
+	}
+	b.WriteString("192.0.2.3") // no trailing newline
+
+	return b.String()
+}
+
+// summarizeList returns the parsed content of l, leaving out the indexes built
+// from it.
+func summarizeList(l ipList) map[string]interface{} {
+	nets := make([]string, 0, len(l.nets))
+	for _, ipNet := range l.nets {
+		nets = append(nets, ipNet.String())
+	}
+	summary := map[string]interface{}{
+		"ips":      l.ips,
+		"nets":     nets,
+		"order":    l.order,
+		"reasons":  l.reasons,
+		"expiries": l.expiries,
+		"invalid":  l.invalid,
+		"allow":    l.allow,
+	}
+	if l.excluded != nil {
+		summary["excluded"] = l.excluded.ips
+	}
+
+	return summary
+}
+
+// TestParseFileStreamed checks that a blocklist file streamed while parsed
+// yields the same list as one read in one go.
+func TestParseFileStreamed(t *testing.T) {
+	m := newTestMiddleware(t, nil)
+	path := filepath.Join(t.TempDir(), "large.txt")
+	if err := os.WriteFile(path, []byte(testLargeBlocklist()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m.reloadMu.Lock()
+	defer m.reloadMu.Unlock()
+
+	m.streamThreshold = 1 << 30
+	whole, err := m.parseFile(path, m.parseBlocklist, true)
+	if err != nil {
+		t.Fatalf("parseFile read in one go: %v", err)
+	}
+	m.streamThreshold = 1
+	streamed, err := m.parseFile(path, m.parseBlocklist, true)
+	if err != nil {
+		t.Fatalf("parseFile streamed: %v", err)
+	}
+
+	if got, want := summarizeList(streamed), summarizeList(whole); !reflect.DeepEqual(got, want) {
+		t.Errorf("streamed list differs from the list read in one go:\nstreamed %v\nwhole    %v", got, want)
+	}
+	if len(whole.ips) < 5000 {
+		t.Errorf("parsed %d IPs, want the generated ones too", len(whole.ips))
+	}
+}
+
+// TestLoadBlocklistStreamed checks that middlewares loading the same blocklist
+// streamed and in one go block the same addresses.
+func TestLoadBlocklistStreamed(t *testing.T) {
+	list := testLargeBlocklist()
+	newMiddleware := func(streamThreshold int64) *Fail2BanMiddleware {
+		return newTestMiddleware(t, func(c *Config) {
+			c.StreamThreshold = streamThreshold
+			if err := os.WriteFile(c.BlocklistPath, []byte(list), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+	whole := newMiddleware(1 << 30)
+	streamed := newMiddleware(1)
+
+	probes := []string{
+		"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4",
+		"198.51.100.1", "198.51.100.7", "2001:db8::1", "2001:db8:ff::1",
+		"2001:db8::2", "203.0.113.9", "10.0.0.1", "10.19.135.1", "10.19.136.1",
+	}
+	for _, ip := range probes {
+		wantBlocked, wantReason := whole.IsBlocked(ip)
+		if blocked, reason := streamed.IsBlocked(ip); blocked != wantBlocked || reason != wantReason {
+			t.Errorf("IsBlocked(%q) streamed = %v %q, read in one go = %v %q", ip, blocked, reason, wantBlocked, wantReason)
+		}
+	}
+	if blocked, _ := streamed.IsBlocked("10.19.135.1"); !blocked {
+		t.Error("the last generated entry isn't blocked")
+	}
+}
+
+// TestLoadGzippedBlocklist loads testdata/blocklist.txt.gz as a file and from
+// a URL.
+func TestLoadGzippedBlocklist(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "blocklist.txt.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write(data)
+	}))
+	defer server.Close()
+
+	for name, path := range map[string]string{"file": filepath.Join("testdata", "blocklist.txt.gz"), "URL": server.URL + "/blocklist.txt.gz"} {
+		t.Run(name, func(t *testing.T) {
+			m := newTestMiddleware(t, func(c *Config) { c.BlocklistPath = path })
+
+			tests := []struct {
+				ip         string
+				wantReason string
+			}{
+				{"192.0.2.10", "from the gzipped list"},
+				{"198.51.100.200", ruleCIDR},
+				{"2001:db8:10::1", ruleCIDR},
+				{"198.51.100.1", ""},
+			}
+			for _, tt := range tests {
+				blocked, reason := m.IsBlocked(tt.ip)
+				if blocked != (tt.wantReason != "") || blocked && reason != tt.wantReason {
+					t.Errorf("IsBlocked(%q) = %v, %q, want reason %q", tt.ip, blocked, reason, tt.wantReason)
+				}
+			}
+		})
+	}
+}
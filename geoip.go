@@ -0,0 +1,57 @@
+package traefik_plugin
+
+import (
+	"net"
+	"strings"
+)
+
+// geoDB looks up the country of an IP.
+type geoDB interface {
+	// country returns the ISO code of ip's country.
+	country(ip net.IP) (string, error)
+	Close() error
+}
+
+// openGeoIP opens the GeoIP database used to block countries. A database that
+// can't be opened is logged once and disables geo blocking instead of
+// failing startup, degrading the middleware.
+func (m *Fail2BanMiddleware) openGeoIP(path string, countries []string) {
+	if path == "" || len(countries) == 0 {
+		return
+	}
+
+	db, err := openGeoDB(path)
+	if err != nil {
+		m.logger.Error("Error opening GeoIP database, geo blocking disabled", "path", path, "error", err)
+		m.degraded = append(m.degraded, "geoip")
+		return
+	}
+
+	m.geoIP = db
+	m.blockedCountries = make(map[string]struct{}, len(countries))
+	for _, country := range countries {
+		m.blockedCountries[strings.ToUpper(strings.TrimSpace(country))] = struct{}{}
+	}
+}
+
+// blockedCountry returns the ISO code of clientIP's country and whether that
+// country is blocked. Lookup failures count as not blocked.
+func (m *Fail2BanMiddleware) blockedCountry(clientIP string) (string, bool) {
+	if m.geoIP == nil {
+		return "", false
+	}
+
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return "", false
+	}
+
+	code, err := m.geoIP.country(ip)
+	if err != nil {
+		return "", false
+	}
+
+	_, blocked := m.blockedCountries[code]
+
+	return code, blocked
+}
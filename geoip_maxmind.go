@@ -0,0 +1,55 @@
+//go:build geoip
+// +build geoip
+
+package traefik_plugin
+
+import (
+	"net"
+
+	"github.com/oschwald/geoip2-golang"
+)
+
+// maxmindDB is a geoDB or asnDB backed by a MaxMind database. It is only
+// compiled in with the "geoip" build tag, since Traefik's Yaegi interpreter
+// cannot load the MaxMind reader.
+type maxmindDB struct {
+	*geoip2.Reader
+}
+
+// openGeoDB opens the MaxMind database at path.
+func openGeoDB(path string) (geoDB, error) {
+	reader, err := geoip2.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return maxmindDB{reader}, nil
+}
+
+// openASNDB opens the MaxMind ASN database at path.
+func openASNDB(path string) (asnDB, error) {
+	reader, err := geoip2.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return maxmindDB{reader}, nil
+}
+
+func (db maxmindDB) asn(ip net.IP) (uint, error) {
+	record, err := db.ASN(ip)
+	if err != nil {
+		return 0, err
+	}
+
+	return record.AutonomousSystemNumber, nil
+}
+
+func (db maxmindDB) country(ip net.IP) (string, error) {
+	record, err := db.Country(ip)
+	if err != nil {
+		return "", err
+	}
+
+	return record.Country.IsoCode, nil
+}
@@ -0,0 +1,16 @@
+//go:build !geoip
+// +build !geoip
+
+package traefik_plugin
+
+import "errors"
+
+// openGeoDB always fails; build with the "geoip" tag to block countries.
+func openGeoDB(path string) (geoDB, error) {
+	return nil, errors.New("GeoIP support is not compiled in, build with the geoip tag")
+}
+
+// openASNDB always fails; build with the "geoip" tag to block ASNs.
+func openASNDB(path string) (asnDB, error) {
+	return nil, errors.New("ASN support is not compiled in, build with the geoip tag")
+}
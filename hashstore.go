@@ -0,0 +1,62 @@
+package traefik_plugin
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// hashedKeyPrefix starts the keys of hashedBanStore, which can't be mistaken
+// for an IP or a range.
+const hashedKeyPrefix = "hash:"
+
+// minHashSaltBytes is the shortest HashSalt accepted. The address space is
+// small enough to hash in full, so the salt is what keeps hashes from being
+// reversed and must be long enough not to be guessed.
+const minHashSaltBytes = 16
+
+// hashedBanStore is a BanStore keeping its bans under salted hashes of their
+// keys, for HashStoredIPs, so the state file and Redis never hold a client
+// address. Keys already hashed, such as those returned by List or read back
+// from the state file, are used as they are.
+type hashedBanStore struct {
+	store BanStore
+	salt  []byte
+}
+
+func (s *hashedBanStore) Get(key string) (ban, bool) { return s.store.Get(s.hash(key)) }
+
+func (s *hashedBanStore) Set(key string, b ban) { s.store.Set(s.hash(key), b) }
+
+func (s *hashedBanStore) Delete(key string) { s.store.Delete(s.hash(key)) }
+
+// List returns the bans by hashed key.
+func (s *hashedBanStore) List() map[string]ban { return s.store.List() }
+
+// hash returns the salted hash of key: an HMAC-SHA256 keyed with the salt.
+func (s *hashedBanStore) hash(key string) string {
+	if isHashedKey(key) {
+		return key
+	}
+
+	mac := hmac.New(sha256.New, s.salt)
+	mac.Write([]byte(key))
+	return hashedKeyPrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// isHashedKey reports whether key is a hash made by hashedBanStore.
+func isHashedKey(key string) bool {
+	return strings.HasPrefix(key, hashedKeyPrefix)
+}
+
+// storedKey returns the key the ban store keeps the ban on the IP or range key
+// under, hashed with HashStoredIPs. State kept alongside the bans, such as
+// probations, uses it too.
+func (m *Fail2BanMiddleware) storedKey(key string) string {
+	if s, ok := m.bans.(*hashedBanStore); ok {
+		return s.hash(key)
+	}
+
+	return key
+}
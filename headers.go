@@ -0,0 +1,135 @@
+package traefik_plugin
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// HeaderRule matches requests by a header, to catch malformed bot traffic
+// regardless of its IP. A rule matches when Missing is set and the header is
+// absent or empty, or when the header's value matches the regular expression
+// Pattern. A matching rule blocks the request, or with a positive Score adds
+// that score toward the automatic ban of its IP instead. The Host header is
+// read from the request's host.
+type HeaderRule struct {
+	// Name is reported as the reason of blocks. Defaults to the header.
+	Name    string `json:"name"`
+	Header  string `json:"header"`
+	Missing bool   `json:"missing"`
+	Pattern string `json:"pattern"`
+	Score   int    `json:"score"`
+}
+
+// headerRule is a compiled HeaderRule.
+type headerRule struct {
+	name    string
+	header  string // canonical form
+	missing bool
+	pattern *regexp.Regexp
+	score   int
+}
+
+// parseHeaderRules compiles the HeaderRules configuration. scoring tells
+// whether automatic banning is enabled, which rules with a score need.
+func parseHeaderRules(rules []HeaderRule, scoring bool) ([]headerRule, error) {
+	compiled := make([]headerRule, 0, len(rules))
+	for i, rule := range rules {
+		if rule.Header == "" {
+			return nil, fmt.Errorf("headerRules[%d]: header is required", i)
+		}
+		if rule.Missing == (rule.Pattern != "") {
+			return nil, fmt.Errorf("headerRules[%d]: set either missing or pattern", i)
+		}
+		if rule.Score < 0 {
+			return nil, fmt.Errorf("headerRules[%d]: score cannot be negative", i)
+		}
+		if rule.Score > 0 && !scoring {
+			return nil, fmt.Errorf("headerRules[%d]: score requires maxRequests or scoreThreshold", i)
+		}
+
+		r := headerRule{
+			name:    rule.Name,
+			header:  http.CanonicalHeaderKey(rule.Header),
+			missing: rule.Missing,
+			score:   rule.Score,
+		}
+		if r.name == "" {
+			r.name = r.header
+		}
+		if rule.Pattern != "" {
+			var err error
+			r.pattern, err = regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("headerRules[%d]: invalid pattern: %w", i, err)
+			}
+		}
+		compiled = append(compiled, r)
+	}
+
+	return compiled, nil
+}
+
+// matches reports whether req matches the rule.
+func (r headerRule) matches(req *http.Request) bool {
+	value := req.Header.Get(r.header)
+	if r.header == "Host" {
+		value = req.Host
+	}
+
+	if r.missing {
+		return value == ""
+	}
+	return r.pattern.MatchString(value)
+}
+
+// matchHeaderRules returns the first blocking rule req matches, or nil, along
+// with the total score of the scoring rules it matches.
+func (m *Fail2BanMiddleware) matchHeaderRules(req *http.Request) (*headerRule, int) {
+	score := 0
+	for i := range m.headerRules {
+		rule := &m.headerRules[i]
+		if !rule.matches(req) {
+			continue
+		}
+		if rule.score == 0 {
+			return rule, score
+		}
+		score += rule.score
+	}
+
+	return nil, score
+}
+
+// headerStripper removes the StripHeaders from requests before passing them
+// to next.
+type headerStripper struct {
+	next    http.Handler
+	headers []string // canonical form
+}
+
+// newHeaderStripper returns next wrapped to strip headers, except keep, or
+// next itself if there is nothing to strip.
+func newHeaderStripper(next http.Handler, headers []string, keep string) http.Handler {
+	keep = http.CanonicalHeaderKey(keep)
+	var canonical []string
+	for _, header := range headers {
+		header = http.CanonicalHeaderKey(strings.TrimSpace(header))
+		if header != keep {
+			canonical = append(canonical, header)
+		}
+	}
+	if len(canonical) == 0 {
+		return next
+	}
+
+	return headerStripper{next: next, headers: canonical}
+}
+
+func (s headerStripper) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	for _, header := range s.headers {
+		req.Header.Del(header)
+	}
+	s.next.ServeHTTP(rw, req)
+}
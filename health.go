@@ -0,0 +1,65 @@
+package traefik_plugin
+
+import (
+	"net/http"
+	"time"
+)
+
+// Health describes whether the blocklist is loaded and fresh.
+type Health struct {
+	// Ready is false until the blocklist has loaded once, and while reloads
+	// have been failing for longer than the staleness threshold.
+	Ready                bool       `json:"ready"`
+	LastSuccessfulReload *time.Time `json:"lastSuccessfulReload,omitempty"`
+	LastReloadError      string     `json:"lastReloadError,omitempty"`
+	// Degraded names the components that failed to load, such as "geoip"
+	// or "asn", whose checks are skipped, or fail requests with FailClosed.
+	Degraded []string `json:"degraded,omitempty"`
+}
+
+// Health reports the outcome of the latest blocklist reloads.
+func (m *Fail2BanMiddleware) Health() Health {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	h := Health{Degraded: m.degraded}
+	if m.lastReloadError != nil {
+		h.LastReloadError = m.lastReloadError.Error()
+	}
+	if m.lastSuccessfulReload.IsZero() {
+		return h
+	}
+
+	last := m.lastSuccessfulReload.UTC()
+	h.LastSuccessfulReload = &last
+	h.Ready = m.lastReloadError == nil || m.nowFunc().Sub(m.lastSuccessfulReload) <= m.healthStaleness
+
+	return h
+}
+
+// recordReload records the outcome of a blocklist reload finished at now.
+func (m *Fail2BanMiddleware) recordReload(err error, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastReloadError = err
+	if err == nil {
+		m.lastSuccessfulReload = now
+	}
+}
+
+// handleHealth serves Health, with a 503 status when not ready.
+func (m *Fail2BanMiddleware) handleHealth(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeMethodNotAllowed(rw, http.MethodGet)
+		return
+	}
+
+	h := m.Health()
+	status := http.StatusOK
+	if !h.Ready {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeJSON(rw, status, h)
+}
@@ -0,0 +1,120 @@
+package traefik_plugin
+
+import (
+	"net/http"
+	"time"
+)
+
+// Actions available to Config.HoneypotAction.
+const (
+	honeypotRecord = "record"
+	honeypotBan    = "ban"
+)
+
+// suspectTTL is how long a client that requested a honeypot path in record
+// mode stays a suspect.
+const suspectTTL = time.Hour
+
+// honeypot handles a request for one of HoneypotPaths from clientIP at now,
+// and reports whether it blocked it. In ban mode the IP is banned like an
+// automatic ban and the request is blocked when enforcing; in record mode the
+// IP becomes a suspect and the request is served.
+func (m *Fail2BanMiddleware) honeypot(rw http.ResponseWriter, req *http.Request, clientIP string, now time.Time, enforcing bool) bool {
+	if !hasAnyPrefix(m.requestPath(req), m.honeypotPaths) {
+		return false
+	}
+
+	if !m.honeypotBans {
+		m.recordSuspect(clientIP, now)
+		m.logger.Info("Recorded honeypot request", "ip", m.logIP(clientIP), "path", req.URL.Path)
+		return false
+	}
+
+	b := ban{rule: ruleHoneypot, reason: "requested " + req.URL.Path, hits: &banHits{}}
+
+	m.mu.Lock()
+	banTime, _ := m.nextBanTime(clientIP, now)
+	if banTime > 0 {
+		b.expiry = now.Add(banTime)
+	}
+	m.bans.Set(clientIP, b)
+	delete(m.unbanned, clientIP)
+	m.forgetClient(clientIP)
+	m.mu.Unlock()
+	m.cache.invalidate(clientIP)
+	m.shareBan(clientIP, b)
+	m.notifyBan(clientIP, b, now, m.traceID(req))
+
+	m.logger.Info("Banning IP", "ip", m.logIP(clientIP), "reason", b.reason, "banTime", banTime)
+
+	if !enforcing {
+		m.metrics.requestWouldBlock()
+		m.logger.Info("Would block request", "ip", m.logIP(clientIP), "rule", b.rule, "reason", b.reason, "path", req.URL.Path)
+		return false
+	}
+
+	b.hits.record(now)
+	m.countBlocked(ruleHoneypot, m.traceID(req))
+	m.decided(clientIP, true, ruleHoneypot)
+	m.block(rw, req, clientIP, b, now)
+
+	return true
+}
+
+// recordSuspect makes clientIP a suspect until suspectTTL after now. Beyond
+// MaxTrackedIPs suspects, expired ones are dropped first, then arbitrary ones.
+func (m *Fail2BanMiddleware) recordSuspect(clientIP string, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.suspects[clientIP]; !ok && m.maxTrackedIPs > 0 && len(m.suspects) >= m.maxTrackedIPs {
+		for ip, expiry := range m.suspects {
+			if !now.Before(expiry) {
+				delete(m.suspects, ip)
+			}
+		}
+		for ip := range m.suspects {
+			if len(m.suspects) < m.maxTrackedIPs {
+				break
+			}
+			delete(m.suspects, ip)
+		}
+	}
+	m.suspects[clientIP] = now.Add(suspectTTL)
+}
+
+// sweepSuspects periodically drops expired suspects. It returns once m.ctx is
+// cancelled.
+func (m *Fail2BanMiddleware) sweepSuspects() {
+	ticker := time.NewTicker(suspectTTL / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			now := m.nowFunc()
+			m.mu.Lock()
+			for ip, expiry := range m.suspects {
+				if !now.Before(expiry) {
+					delete(m.suspects, ip)
+				}
+			}
+			m.mu.Unlock()
+		}
+	}
+}
+
+// suspectUntil returns when clientIP stops being a suspect, or the zero time
+// if it isn't one at now.
+func (m *Fail2BanMiddleware) suspectUntil(clientIP string, now time.Time) time.Time {
+	m.mu.RLock()
+	expiry, ok := m.suspects[clientIP]
+	m.mu.RUnlock()
+	if !ok || !now.Before(expiry) {
+		return time.Time{}
+	}
+
+	return expiry
+}
@@ -0,0 +1,26 @@
+package traefik_plugin
+
+// decisionHook wraps the OnDecision callback for m.onDecision, since an
+// atomic.Value can't hold nil.
+type decisionHook struct {
+	fn func(ip string, blocked bool, reason string)
+}
+
+// OnDecision sets fn to be called by ServeHTTP with the outcome of every
+// request from a client it checks: allowed, or blocked along with the rule
+// that blocked it, such as "exact" or "rate_limit". Requests served in dry
+// run or while paused count as allowed, and requests passed through
+// unchecked, by scope, bypass or private range, aren't reported. fn is called
+// synchronously before the request is served or rejected, so it adds to the
+// latency of every request and should hand anything slow off to a goroutine.
+// A nil fn removes the callback. It may be called while serving requests.
+func (m *Fail2BanMiddleware) OnDecision(fn func(ip string, blocked bool, reason string)) {
+	m.onDecision.Store(decisionHook{fn: fn})
+}
+
+// decided reports a decision to the OnDecision callback, if any.
+func (m *Fail2BanMiddleware) decided(clientIP string, blocked bool, reason string) {
+	if hook, _ := m.onDecision.Load().(decisionHook); hook.fn != nil {
+		hook.fn(clientIP, blocked, reason)
+	}
+}
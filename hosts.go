@@ -0,0 +1,69 @@
+package traefik_plugin
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// hostLookupTimeout bounds resolving one blocklist hostname.
+	hostLookupTimeout = 5 * time.Second
+
+	// negativeLookupTTL is how long a hostname that failed to resolve is not
+	// looked up again, so reloads don't keep hammering DNS for it.
+	negativeLookupTTL = time.Minute
+)
+
+// isHostname reports whether entry looks like a DNS hostname, as opposed to a
+// malformed IP or an arbitrary string.
+func isHostname(entry string) bool {
+	if len(entry) > 253 || strings.IndexFunc(entry, isLetter) < 0 {
+		return false
+	}
+
+	for _, label := range strings.Split(strings.TrimSuffix(entry, "."), ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !isLetter(r) && (r < '0' || r > '9') && r != '-' {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func isLetter(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+
+// resolveHost returns the normalized addresses host resolves to. Failed
+// lookups are logged and remembered for negativeLookupTTL. The caller must
+// hold m.reloadMu.
+func (m *Fail2BanMiddleware) resolveHost(host string, now time.Time) []string {
+	if retryAt, ok := m.negativeLookups[host]; ok && now.Before(retryAt) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(m.reloadContext(), hostLookupTimeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		m.logger.Warn("Failed to resolve blocklist hostname", "host", host, "error", err)
+		m.negativeLookups[host] = now.Add(negativeLookupTTL)
+		return nil
+	}
+	delete(m.negativeLookups, host)
+
+	ips := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, normalizeIP(addr.IP.String()))
+	}
+
+	return ips
+}
@@ -0,0 +1,206 @@
+package traefik_plugin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// Values of Config.BlocklistFormat.
+const (
+	formatLines = "lines"
+	formatCSV   = "csv"
+	formatJSON  = "json"
+	// formatAuto picks one of the others by sniffing each blocklist.
+	formatAuto = "auto"
+)
+
+// formatSniffBytes is how much of a blocklist formatAuto looks at.
+const formatSniffBytes = 4096
+
+// parseBlocklist parses the blocklist read from r in m.blocklistFormat and
+// rejects its CIDRs broader than the minimum prefix lengths.
+func (m *Fail2BanMiddleware) parseBlocklist(r io.Reader, list string, resolveHosts bool) (ipList, error) {
+	parsed, err := m.parseFormatted(r, list, resolveHosts)
+	if err != nil {
+		return ipList{}, err
+	}
+	m.rejectBroadCIDRs(&parsed, list)
+
+	return parsed, nil
+}
+
+// parseFormatted parses the blocklist read from r in m.blocklistFormat. CSV
+// and JSON blocklists are turned into one entry per line and parsed by
+// parseIPList like a plain list, so their entries may carry the same
+// annotations.
+func (m *Fail2BanMiddleware) parseFormatted(r io.Reader, list string, resolveHosts bool) (ipList, error) {
+	br := bufio.NewReader(r)
+	format := m.blocklistFormat
+	if format == formatAuto {
+		format = sniffFormat(br)
+		m.logger.Debug("Detected blocklist format", "list", list, "format", format)
+	}
+
+	var entries []string
+	var err error
+	switch format {
+	case formatCSV:
+		entries, err = csvEntries(br, m.blocklistCSVColumn)
+	case formatJSON:
+		entries, err = jsonEntries(br)
+	default:
+		return m.parseIPList(br, list, resolveHosts)
+	}
+	if err != nil {
+		return ipList{}, err
+	}
+
+	return m.parseIPList(strings.NewReader(strings.Join(entries, "\n")), list, resolveHosts)
+}
+
+// sniffFormat guesses the format of the blocklist buffered in br: JSON if it
+// starts with an array, CSV if its first entry line has several fields, and
+// lines otherwise.
+func sniffFormat(br *bufio.Reader) string {
+	head, _ := br.Peek(formatSniffBytes)
+	head = bytes.TrimLeft(head, " \t\r\n\ufeff")
+	if bytes.HasPrefix(head, []byte("[")) {
+		return formatJSON
+	}
+
+	for _, line := range strings.Split(string(head), "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line == "" {
+			continue
+		}
+
+		fields := 0
+		for _, field := range strings.Split(line, ",") {
+			if strings.TrimSpace(field) != "" {
+				fields++
+			}
+		}
+		if fields > 1 {
+			return formatCSV
+		}
+		return formatLines
+	}
+
+	return formatLines
+}
+
+// csvEntries returns the field at column of each record of the CSV read from
+// r. Lines starting with "#" are comments, and a first record whose field is
+// neither an IP or CIDR nor dotted like a hostname is taken for a header and
+// skipped. Records too short to have the column are returned whole so they
+// are rejected as invalid.
+func csvEntries(r io.Reader, column int) ([]string, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	cr.TrimLeadingSpace = true
+
+	var entries []string
+	for first := true; ; first = false {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		if column >= len(record) {
+			entries = append(entries, strings.Join(record, ","))
+			continue
+		}
+
+		entry := strings.TrimSpace(record[column])
+		if first && !validEntry(entry) && !strings.Contains(entry, ".") {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// jsonEntries returns the entries of the JSON array read from r, whose
+// elements are strings or objects with an "ip" string.
+func jsonEntries(r io.Reader) ([]string, error) {
+	var elements []interface{}
+	if err := json.NewDecoder(r).Decode(&elements); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	entries := make([]string, 0, len(elements))
+	for i, element := range elements {
+		switch element := element.(type) {
+		case string:
+			entries = append(entries, element)
+		case map[string]interface{}:
+			ip, ok := element["ip"].(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid JSON: element %d has no ip", i)
+			}
+			entries = append(entries, ip)
+		default:
+			return nil, fmt.Errorf("invalid JSON: element %d is neither a string nor an object", i)
+		}
+	}
+
+	return entries, nil
+}
+
+// rejectBroadCIDRs drops the CIDRs of parsed with a prefix shorter than
+// MinCIDRPrefixV4 or MinCIDRPrefixV6, such as a stray 0.0.0.0/0 that would
+// block everyone, and collects them in its invalid list.
+func (m *Fail2BanMiddleware) rejectBroadCIDRs(parsed *ipList, list string) {
+	if m.minCIDRPrefixV4 == 0 && m.minCIDRPrefixV6 == 0 {
+		return
+	}
+
+	rejected := make(map[string]struct{})
+	nets := parsed.nets[:0]
+	for _, ipNet := range parsed.nets {
+		ones, bits := ipNet.Mask.Size()
+		min := m.minCIDRPrefixV6
+		if bits == 8*net.IPv4len {
+			min = m.minCIDRPrefixV4
+		}
+		if ones >= min {
+			nets = append(nets, ipNet)
+			continue
+		}
+
+		entry := ipNet.String()
+		m.logger.Warn("Rejecting overly broad CIDR", "list", list, "entry", entry, "minPrefix", min)
+		rejected[entry] = struct{}{}
+		parsed.invalid = append(parsed.invalid, entry)
+	}
+	if len(rejected) == 0 {
+		return
+	}
+	parsed.nets = nets
+
+	order := parsed.order[:0]
+	for _, entry := range parsed.order {
+		if _, ok := rejected[entry]; !ok {
+			order = append(order, entry)
+		}
+	}
+	parsed.order = order
+	for entry := range rejected {
+		delete(parsed.reasons, entry)
+		delete(parsed.expiries, entry)
+	}
+}
@@ -0,0 +1,66 @@
+package traefik_plugin
+
+import (
+	"strings"
+	"time"
+)
+
+// listModePrefix starts the header comment of a blocklist file telling how its
+// entries are interpreted, as in "# mode: allow".
+const listModePrefix = "mode:"
+
+// Values of the mode header of blocklist files.
+const (
+	listModeDeny  = "deny"
+	listModeAllow = "allow"
+)
+
+// parseListMode returns the mode set by the comment of a header line, without
+// its "#", and whether the comment is a mode header at all.
+func parseListMode(comment string) (string, bool) {
+	mode := strings.TrimPrefix(comment, listModePrefix)
+	if mode == comment {
+		return "", false
+	}
+
+	return strings.ToLower(strings.TrimSpace(mode)), true
+}
+
+// mergeAllowSources returns the union of the entries of the loaded blocklist
+// sources at paths whose header sets the allow mode, leaving out entries
+// expired at now. It is consulted along with the allowlist. The caller must
+// hold m.reloadMu.
+func (m *Fail2BanMiddleware) mergeAllowSources(paths []string, now time.Time) *ipList {
+	merged := &ipList{ips: make(map[string]struct{})}
+	seenNets := make(map[string]struct{})
+
+	for _, path := range paths {
+		src, ok := m.sources[path]
+		if !ok || src.list == nil || !src.list.allow {
+			continue
+		}
+		list := src.list
+
+		for ip := range list.ips {
+			if !list.expired(ip, now) {
+				merged.ips[ip] = struct{}{}
+			}
+		}
+		for _, ipNet := range list.nets {
+			key := ipNet.String()
+			if _, ok := seenNets[key]; !ok && !list.expired(key, now) {
+				seenNets[key] = struct{}{}
+				merged.nets = append(merged.nets, ipNet)
+			}
+		}
+	}
+	merged.index()
+
+	return merged
+}
+
+// allowlisted reports whether clientIP is on the allowlist or on a blocklist
+// file in the allow mode.
+func (m *Fail2BanMiddleware) allowlisted(clientIP string) bool {
+	return m.currentAllowlist().contains(clientIP) || m.sourceAllowlist.Load().(*ipList).contains(clientIP)
+}
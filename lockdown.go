@@ -0,0 +1,76 @@
+package traefik_plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync/atomic"
+)
+
+// lockdownState is the body of POST /lockdown and the response of both
+// /lockdown methods.
+type lockdownState struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// inLockdown reports whether clients not on the allowlist are rejected, either
+// because DefaultDeny is configured or because lockdown was enabled at runtime.
+func (m *Fail2BanMiddleware) inLockdown() bool {
+	return atomic.LoadUint32(&m.defaultDeny) == 1
+}
+
+// setLockdown turns DefaultDeny on or off until the next change or until
+// Traefik recreates the middleware. Enabling it fails while the allowlist is
+// empty, since that would reject every client.
+func (m *Fail2BanMiddleware) setLockdown(enabled bool) error {
+	var state uint32
+	if enabled {
+		list := m.currentAllowlist()
+		sourced := m.sourceAllowlist.Load().(*ipList)
+		if len(list.ips) == 0 && len(list.nets) == 0 && len(sourced.ips) == 0 && len(sourced.nets) == 0 {
+			return errors.New("lockdown requires a non-empty allowlist")
+		}
+		state = 1
+	}
+
+	if atomic.SwapUint32(&m.defaultDeny, state) != state {
+		m.cache.purge()
+		m.metrics.setLockdown(enabled)
+	}
+
+	return nil
+}
+
+// handleLockdown reports the lockdown state on GET and changes it on POST.
+func (m *Fail2BanMiddleware) handleLockdown(rw http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var body lockdownState
+		if err := json.NewDecoder(http.MaxBytesReader(rw, req.Body, maxAdminBodyBytes)).Decode(&body); err != nil {
+			writeJSONError(rw, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+		if body.Enabled == nil {
+			writeJSONError(rw, http.StatusBadRequest, "enabled is required")
+			return
+		}
+
+		if err := m.setLockdown(*body.Enabled); err != nil {
+			writeJSONError(rw, http.StatusConflict, err.Error())
+			return
+		}
+
+		if *body.Enabled {
+			m.logger.Warn("Lockdown enabled via admin API, rejecting clients not on the allowlist")
+		} else {
+			m.logger.Info("Lockdown disabled via admin API")
+		}
+	default:
+		writeMethodNotAllowed(rw, http.MethodGet+", "+http.MethodPost)
+		return
+	}
+
+	enabled := m.inLockdown()
+	writeJSON(rw, http.StatusOK, lockdownState{Enabled: &enabled})
+}
@@ -0,0 +1,210 @@
+package traefik_plugin
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestReloadDuringRequests reloads the blocklist while requests are served, for
+// the race detector. Both lists block 192.0.2.1, so a request that ever sees a
+// half-swapped list fails.
+func TestReloadDuringRequests(t *testing.T) {
+	var path string
+	m := newTestMiddleware(t, func(c *Config) {
+		path = c.BlocklistPath
+		c.DecisionCacheSize = 0
+	})
+
+	lists := []string{
+		testBlocklist,
+		"192.0.2.1\n192.0.2.0/28\n2001:db8::/32\n",
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if rec := serve(m, "192.0.2.1:4321", "/", nil); rec.Code != http.StatusForbidden {
+					t.Errorf("blocked IP: status = %d, want %d", rec.Code, http.StatusForbidden)
+					return
+				}
+				if rec := serve(m, "203.0.113.7:4321", "/", nil); rec.Code != http.StatusOK {
+					t.Errorf("allowed IP: status = %d, want %d", rec.Code, http.StatusOK)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 50; i++ {
+		if err := os.WriteFile(path, []byte(lists[i%len(lists)]), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.reloadBlocklist(); err != nil {
+			t.Fatalf("reloadBlocklist: %v", err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	if rec := serve(m, "192.0.2.5:4321", "/", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("entry of the last list loaded: status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mod     func(*Config)
+		wantErr string
+	}{
+		{name: "defaults", mod: func(c *Config) {}},
+		{
+			name: "banning",
+			mod: func(c *Config) {
+				c.MaxRequests = 5
+				c.IPv4BanPrefix = 24
+				c.IPv6BanPrefix = 64
+			},
+		},
+		{
+			name: "rate limit",
+			mod: func(c *Config) {
+				c.RateLimit = 10
+				c.RateWindow = time.Second
+				c.RateBurst = 5
+			},
+		},
+		{
+			name: "hashed IPs",
+			mod: func(c *Config) {
+				c.HashStoredIPs = true
+				c.HashSalt = "0123456789abcdef"
+			},
+		},
+		{
+			name:    "empty blocklist",
+			mod:     func(c *Config) { c.BlocklistPath = "" },
+			wantErr: "blocklistPath cannot be empty",
+		},
+		{
+			name:    "unknown precedence",
+			mod:     func(c *Config) { c.Precedence = "random" },
+			wantErr: `unknown precedence "random", want "allow-first" or "block-first"`,
+		},
+		{
+			name:    "short reload interval",
+			mod:     func(c *Config) { c.ReloadInterval = time.Millisecond },
+			wantErr: "reloadInterval must be at least 1s, or 0 to disable periodic reloads",
+		},
+		{
+			name:    "negative maxConcurrentPerIP",
+			mod:     func(c *Config) { c.MaxConcurrentPerIP = -1 },
+			wantErr: "maxConcurrentPerIP cannot be negative",
+		},
+		{
+			name: "short hash salt",
+			mod: func(c *Config) {
+				c.HashStoredIPs = true
+				c.HashSalt = "short"
+			},
+			wantErr: "hashStoredIPs requires a hashSalt of at least 16 bytes",
+		},
+		{
+			name:    "rate window without limit",
+			mod:     func(c *Config) { c.RateWindow = time.Second },
+			wantErr: "rateLimit is required when rateWindow is set",
+		},
+		{
+			name:    "ban prefix without banning",
+			mod:     func(c *Config) { c.IPv4BanPrefix = 24 },
+			wantErr: "ipv6BanPrefix and ipv4BanPrefix require maxRequests or scoreThreshold",
+		},
+		{
+			name:    "IPv6 ban prefix out of range",
+			mod:     func(c *Config) { c.IPv6BanPrefix = 129 },
+			wantErr: "ipv6BanPrefix must be between 0 and 128",
+		},
+		{
+			name: "failOpen and failClosed",
+			mod: func(c *Config) {
+				c.FailOpen = true
+				c.FailClosed = true
+			},
+			wantErr: "failOpen and failClosed are mutually exclusive",
+		},
+		{
+			name:    "relative exclude path",
+			mod:     func(c *Config) { c.ExcludePaths = []string{"health"} },
+			wantErr: `excludePaths[0]: path "health" must start with /`,
+		},
+		{
+			name:    "unknown CDN provider",
+			mod:     func(c *Config) { c.CDNProviders = []string{"acme"} },
+			wantErr: `cdnProviders[0]: unknown provider "acme"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := CreateConfig()
+			tt.mod(config)
+
+			err := config.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("Validate() = %v, want nil", err)
+			case tt.wantErr != "" && err == nil:
+				t.Fatalf("Validate() = nil, want %q", tt.wantErr)
+			case tt.wantErr != "" && err.Error() != tt.wantErr:
+				t.Fatalf("Validate() = %q, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestPrecedence checks how a client on both the allowlist and the blocklist
+// is treated under each Precedence.
+func TestPrecedence(t *testing.T) {
+	tests := []struct {
+		precedence string
+		// wantConflict is the status for 198.51.100.42, in both an allowlisted
+		// and a blocklisted range.
+		wantConflict int
+	}{
+		{precedenceAllowFirst, http.StatusOK},
+		{precedenceBlockFirst, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.precedence, func(t *testing.T) {
+			m := newTestMiddleware(t, func(c *Config) {
+				c.Precedence = tt.precedence
+				c.AllowlistPath = filepath.Join(filepath.Dir(c.BlocklistPath), "allowlist.txt")
+				if err := os.WriteFile(c.AllowlistPath, []byte("198.51.100.0/24\n203.0.113.0/24\n"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			})
+
+			if rec := serve(m, "198.51.100.42:4321", "/", nil); rec.Code != tt.wantConflict {
+				t.Errorf("allowlisted and blocklisted IP: status = %d, want %d", rec.Code, tt.wantConflict)
+			}
+			if rec := serve(m, "203.0.113.7:4321", "/", nil); rec.Code != http.StatusOK {
+				t.Errorf("allowlisted IP: status = %d, want %d", rec.Code, http.StatusOK)
+			}
+			if rec := serve(m, "192.0.2.1:4321", "/", nil); rec.Code != http.StatusForbidden {
+				t.Errorf("blocklisted IP: status = %d, want %d", rec.Code, http.StatusForbidden)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package traefik_plugin
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Matcher is a block rule. Matchers are evaluated in order for clients that are
+// neither allowlisted nor dynamically banned, and the first one that matches
+// blocks the request with its reason. Decisions are cached per client IP for
+// DecisionCacheTTL, so a Matcher should decide on the IP alone and use r only
+// for context.
+type Matcher interface {
+	Match(ip net.IP, r *http.Request) (blocked bool, reason string)
+}
+
+// MatcherFactory builds a custom Matcher from the middleware's configuration.
+type MatcherFactory func(config *Config) (Matcher, error)
+
+// matcherFactories holds the custom matchers by name. It is only written by
+// RegisterMatcher from init functions, so it needs no lock.
+var matcherFactories = make(map[string]MatcherFactory)
+
+// RegisterMatcher makes a custom matcher available to Config.Matchers under
+// name. It is meant to be called from the init function of a file added to the
+// plugin, and panics if name is already taken.
+func RegisterMatcher(name string, factory MatcherFactory) {
+	if _, ok := matcherFactories[name]; ok {
+		panic(fmt.Sprintf("matcher %q already registered", name))
+	}
+	matcherFactories[name] = factory
+}
+
+// ruleMatcher is a link of the matcher chain. Besides the verdict it reports
+// the full ban, so the built-in rules keep their rule, source and expiry.
+type ruleMatcher interface {
+	match(clientIP string, req *http.Request, now time.Time) (ban, bool)
+}
+
+// newMatchers assembles the matcher chain: the blocklist's exact entries, its
+// CIDRs, the blocked countries and ASNs when their databases are open, the
+// custom matchers named in config in order, then the reverse DNS patterns.
+func (m *Fail2BanMiddleware) newMatchers(config *Config) ([]ruleMatcher, error) {
+	matchers := []ruleMatcher{exactMatcher{m: m}, cidrMatcher{m: m}}
+	if m.geoIP != nil {
+		matchers = append(matchers, geoMatcher{m})
+	}
+	if m.asnDB != nil {
+		matchers = append(matchers, asnMatcher{m})
+	}
+
+	for _, name := range config.Matchers {
+		factory, ok := matcherFactories[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown matcher %q", name)
+		}
+		matcher, err := factory(config)
+		if err != nil {
+			return nil, fmt.Errorf("matcher %s: %w", name, err)
+		}
+		matchers = append(matchers, customMatcher{name: name, matcher: matcher})
+	}
+
+	if m.ptrCache != nil {
+		matchers = append(matchers, ptrMatcher{m})
+	}
+
+	return matchers, nil
+}
+
+// exactMatcher matches the blocklist's exact IPs, including the addresses of
+// its hostnames, or those of the shadow blocklist when shadow is set.
+type exactMatcher struct {
+	m      *Fail2BanMiddleware
+	shadow bool
+}
+
+func (e exactMatcher) Match(ip net.IP, r *http.Request) (bool, string) {
+	b, ok := e.match(ip.String(), r, e.m.nowFunc())
+	return ok, b.reason
+}
+
+func (e exactMatcher) match(clientIP string, _ *http.Request, now time.Time) (ban, bool) {
+	list := e.m.matchedBlocklist(e.shadow)
+	if _, ok := list.ips[clientIP]; !ok || list.expired(clientIP, now) {
+		return ban{}, false
+	}
+	if !e.shadow {
+		if _, ok := e.m.liftedEntries(clientIP, now)[clientIP]; ok {
+			return ban{}, false
+		}
+	}
+
+	rule := ruleExact
+	if _, ok := list.hosts[clientIP]; ok {
+		rule = ruleHost
+	}
+
+	return ban{rule: rule, reason: list.reasons[clientIP], source: list.sources[clientIP], expiry: list.expiries[clientIP]}, true
+}
+
+// cidrMatcher matches the blocklist's CIDRs, minus its exclusions, or those of
+// the shadow blocklist when shadow is set.
+type cidrMatcher struct {
+	m      *Fail2BanMiddleware
+	shadow bool
+}
+
+func (c cidrMatcher) Match(ip net.IP, r *http.Request) (bool, string) {
+	b, ok := c.match(ip.String(), r, c.m.nowFunc())
+	return ok, b.reason
+}
+
+func (c cidrMatcher) match(clientIP string, _ *http.Request, now time.Time) (ban, bool) {
+	list := c.m.matchedBlocklist(c.shadow)
+
+	// An expired CIDR hides the ranges enclosing it until the next reload
+	// drops it. A lifted one doesn't, so a broader range still applies.
+	ipNet := list.matchNet(clientIP)
+	if !c.shadow && ipNet != nil {
+		if lifted := c.m.liftedEntries(clientIP, now); len(lifted) > 0 {
+			ipNet = list.matchNetExcept(clientIP, lifted)
+		}
+	}
+	if ipNet == nil || list.expired(ipNet.String(), now) || list.excludes(clientIP) {
+		return ban{}, false
+	}
+
+	key := ipNet.String()
+	return ban{rule: ruleCIDR, reason: list.reasons[key], source: list.sources[key], expiry: list.expiries[key], cidr: ipNet}, true
+}
+
+// geoMatcher matches clients located in a blocked country.
+type geoMatcher struct{ m *Fail2BanMiddleware }
+
+func (g geoMatcher) Match(ip net.IP, r *http.Request) (bool, string) {
+	b, ok := g.match(ip.String(), r, g.m.nowFunc())
+	return ok, b.reason
+}
+
+func (g geoMatcher) match(clientIP string, _ *http.Request, _ time.Time) (ban, bool) {
+	country, ok := g.m.blockedCountry(clientIP)
+	if !ok {
+		return ban{}, false
+	}
+
+	return ban{rule: ruleGeo, reason: country}, true
+}
+
+// customMatcher adapts a registered Matcher to the chain, reporting its name
+// as the rule.
+type customMatcher struct {
+	name    string
+	matcher Matcher
+}
+
+func (c customMatcher) match(clientIP string, req *http.Request, _ time.Time) (ban, bool) {
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return ban{}, false
+	}
+
+	blocked, reason := c.matcher.Match(ip, req)
+	if !blocked {
+		return ban{}, false
+	}
+
+	return ban{rule: c.name, reason: reason}, true
+}
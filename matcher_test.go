@@ -0,0 +1,62 @@
+package traefik_plugin
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+// fakeGeoDB is a geoDB with fixed countries by IP.
+type fakeGeoDB map[string]string
+
+func (db fakeGeoDB) country(ip net.IP) (string, error) {
+	code, ok := db[ip.String()]
+	if !ok {
+		return "", errors.New("not found")
+	}
+	return code, nil
+}
+
+func (db fakeGeoDB) Close() error { return nil }
+
+// TestBuiltinMatchers checks the verdicts of the blocklist and geo matchers of
+// the chain, and that they are evaluated in order.
+func TestBuiltinMatchers(t *testing.T) {
+	m := newTestMiddleware(t, nil)
+	m.geoIP = fakeGeoDB{
+		"192.0.2.1":   "XX", // also listed
+		"203.0.113.7": "XX",
+		"203.0.113.8": "YY",
+	}
+	m.blockedCountries = map[string]struct{}{"XX": {}}
+	matchers, err := m.newMatchers(CreateConfig())
+	if err != nil {
+		t.Fatalf("newMatchers: %v", err)
+	}
+
+	tests := []struct {
+		ip         string
+		wantRule   string
+		wantReason string
+		wantCIDR   string
+	}{
+		{ip: "192.0.2.1", wantRule: ruleExact, wantReason: "exact"},
+		{ip: "198.51.100.42", wantRule: ruleCIDR, wantCIDR: "198.51.100.0/24"},
+		{ip: "203.0.113.7", wantRule: ruleGeo, wantReason: "XX"},
+		{ip: "203.0.113.8"},
+		{ip: "192.0.2.2"},
+	}
+	for _, tt := range tests {
+		b, blocked, _ := m.isBlocked(tt.ip, nil, m.nowFunc(), matchers)
+		if blocked != (tt.wantRule != "") {
+			t.Errorf("%s: blocked = %v, want %v", tt.ip, blocked, tt.wantRule != "")
+			continue
+		}
+		if b.rule != tt.wantRule || b.reason != tt.wantReason {
+			t.Errorf("%s: rule %q, reason %q, want %q, %q", tt.ip, b.rule, b.reason, tt.wantRule, tt.wantReason)
+		}
+		if cidr := b.cidr; (cidr == nil) != (tt.wantCIDR == "") || (cidr != nil && cidr.String() != tt.wantCIDR) {
+			t.Errorf("%s: matched range %v, want %q", tt.ip, cidr, tt.wantCIDR)
+		}
+	}
+}
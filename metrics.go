@@ -0,0 +1,42 @@
+package traefik_plugin
+
+import "time"
+
+// metrics records what the middleware is doing. The Prometheus implementation
+// is only compiled in with the "prometheus" build tag, because client_golang
+// relies on packages Traefik's Yaegi interpreter cannot load; plugins loaded by
+// Traefik get a no-op implementation instead.
+type metrics interface {
+	// enabled reports whether metrics are recorded, so callers can skip
+	// measuring what would be discarded.
+	enabled() bool
+	// requestBlocked counts a request rejected by the middleware, by the
+	// category of the rule that rejected it, with the request's trace ID, if
+	// any, as an exemplar.
+	requestBlocked(category, traceID string)
+	// requestWouldBlock counts a request that matched a block rule but was
+	// served because of dry-run mode.
+	requestWouldBlock()
+	// shadowDivergence counts a decision the shadow blocklist would have
+	// made differently, by direction: shadow_only or primary_only.
+	shadowDivergence(direction string)
+	// requestAllowed counts a request passed on to the next handler.
+	requestAllowed()
+	// setBlocklistSize records the number of entries in the loaded blocklist.
+	setBlocklistSize(n int)
+	// blocklistChanged counts the entries a blocklist reload added and
+	// removed.
+	blocklistChanged(added, removed int)
+	// setTrackedIPs records the number of IPs whose failures are tracked.
+	setTrackedIPs(n int)
+	// setLockdown records whether clients not on the allowlist are rejected.
+	setLockdown(enabled bool)
+	// setBlocklistStale records whether a blocklist file is older than
+	// MaxBlocklistAge.
+	setBlocklistStale(stale bool)
+	// observeReload records how long a blocklist reload took.
+	observeReload(d time.Duration)
+	// observeDecision records how long deciding whether to block a request
+	// took.
+	observeDecision(d time.Duration)
+}
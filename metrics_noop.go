@@ -0,0 +1,31 @@
+//go:build !prometheus
+// +build !prometheus
+
+package traefik_plugin
+
+import "time"
+
+// noopMetrics discards all metrics.
+type noopMetrics struct{}
+
+// newMetrics returns a no-op metrics implementation; build with the
+// "prometheus" tag to export real metrics.
+func newMetrics(namespace, subsystem, name string) metrics {
+	return noopMetrics{}
+}
+
+func (noopMetrics) enabled() bool { return false }
+
+func (noopMetrics) requestBlocked(string, string) {}
+func (noopMetrics) requestWouldBlock()            {}
+func (noopMetrics) requestAllowed()               {}
+func (noopMetrics) shadowDivergence(string)       {}
+func (noopMetrics) setBlocklistSize(int)          {}
+func (noopMetrics) setTrackedIPs(int)             {}
+func (noopMetrics) setLockdown(bool)              {}
+func (noopMetrics) setBlocklistStale(bool)        {}
+
+func (noopMetrics) blocklistChanged(int, int) {}
+
+func (noopMetrics) observeReload(time.Duration)   {}
+func (noopMetrics) observeDecision(time.Duration) {}
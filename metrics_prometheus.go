@@ -0,0 +1,207 @@
+//go:build prometheus
+// +build prometheus
+
+package traefik_plugin
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// prometheusCollectors holds the collectors shared by all middleware instances
+// using the same namespace and subsystem. Instances are told apart by the
+// "middleware" label.
+type prometheusCollectors struct {
+	blocked       *prometheus.CounterVec
+	wouldBlock    *prometheus.CounterVec
+	allowed       *prometheus.CounterVec
+	shadow        *prometheus.CounterVec
+	blocklistSize *prometheus.GaugeVec
+	added         *prometheus.CounterVec
+	removed       *prometheus.CounterVec
+	trackedIPs    *prometheus.GaugeVec
+	lockdown      *prometheus.GaugeVec
+	stale         *prometheus.GaugeVec
+	reload        *prometheus.HistogramVec
+	decision      *prometheus.HistogramVec
+}
+
+var (
+	collectorsMu sync.Mutex
+	// collectors caches registered collectors by namespace and subsystem, since
+	// Traefik creates a new middleware on every configuration reload and
+	// registering the same metric twice panics.
+	collectors = make(map[[2]string]*prometheusCollectors)
+)
+
+// prometheusMetrics reports metrics for one middleware instance.
+type prometheusMetrics struct {
+	blocked       *prometheus.CounterVec // by category
+	wouldBlock    prometheus.Counter
+	allowed       prometheus.Counter
+	shadow        *prometheus.CounterVec // by direction
+	blocklistSize prometheus.Gauge
+	added         prometheus.Counter
+	removed       prometheus.Counter
+	trackedIPs    prometheus.Gauge
+	lockdown      prometheus.Gauge
+	stale         prometheus.Gauge
+	reload        prometheus.Observer
+	decision      prometheus.Observer
+}
+
+// newMetrics returns metrics registered with the default Prometheus registry.
+func newMetrics(namespace, subsystem, name string) metrics {
+	c := registerCollectors(namespace, subsystem)
+
+	return &prometheusMetrics{
+		blocked:       c.blocked.MustCurryWith(prometheus.Labels{"middleware": name}),
+		wouldBlock:    c.wouldBlock.WithLabelValues(name),
+		allowed:       c.allowed.WithLabelValues(name),
+		shadow:        c.shadow.MustCurryWith(prometheus.Labels{"middleware": name}),
+		blocklistSize: c.blocklistSize.WithLabelValues(name),
+		added:         c.added.WithLabelValues(name),
+		removed:       c.removed.WithLabelValues(name),
+		trackedIPs:    c.trackedIPs.WithLabelValues(name),
+		lockdown:      c.lockdown.WithLabelValues(name),
+		stale:         c.stale.WithLabelValues(name),
+		reload:        c.reload.WithLabelValues(name),
+		decision:      c.decision.WithLabelValues(name),
+	}
+}
+
+// registerCollectors registers the collectors for namespace and subsystem on
+// first use and returns the cached ones afterwards.
+func registerCollectors(namespace, subsystem string) *prometheusCollectors {
+	collectorsMu.Lock()
+	defer collectorsMu.Unlock()
+
+	key := [2]string{namespace, subsystem}
+	if c, ok := collectors[key]; ok {
+		return c
+	}
+
+	labels := []string{"middleware"}
+	c := &prometheusCollectors{
+		blocked: promauto.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "requests_blocked_total",
+			Help:      "Number of requests rejected by the middleware, by the category of the rule that rejected them.",
+		}, []string{"middleware", "category"}),
+		wouldBlock: promauto.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "would_block_total",
+			Help:      "Number of requests that matched a block rule but were served in dry-run mode.",
+		}, labels),
+		allowed: promauto.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "requests_allowed_total",
+			Help:      "Number of requests passed on to the next handler.",
+		}, labels),
+		shadow: promauto.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "shadow_divergences_total",
+			Help:      "Number of decisions the shadow blocklist would have made differently, by direction: shadow_only when only it would block, primary_only when only the blocklists block.",
+		}, []string{"middleware", "direction"}),
+		blocklistSize: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "blocklist_size",
+			Help:      "Number of IP and CIDR entries in the loaded blocklist.",
+		}, labels),
+		added: promauto.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "blocklist_added_total",
+			Help:      "Number of IP and CIDR entries added to the blocklist by reloads.",
+		}, labels),
+		removed: promauto.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "blocklist_removed_total",
+			Help:      "Number of IP and CIDR entries removed from the blocklist by reloads.",
+		}, labels),
+		trackedIPs: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "tracked_ips",
+			Help:      "Number of IPs whose recent failures are tracked toward a ban.",
+		}, labels),
+		lockdown: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "lockdown",
+			Help:      "1 while clients not on the allowlist are rejected, 0 otherwise.",
+		}, labels),
+		stale: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "blocklist_stale",
+			Help:      "1 while a blocklist file is older than the configured maximum age, 0 otherwise.",
+		}, labels),
+		reload: promauto.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "reload_duration_seconds",
+			Help:      "Time taken to reload the blocklist.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+		}, labels),
+		decision: promauto.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "decision_duration_seconds",
+			Help:      "Time taken to decide whether to block a request.",
+			Buckets:   prometheus.ExponentialBuckets(0.000001, 4, 10),
+		}, labels),
+	}
+	collectors[key] = c
+
+	return c
+}
+
+func (p *prometheusMetrics) enabled() bool { return true }
+
+func (p *prometheusMetrics) requestBlocked(c, traceID string) {
+	counter := p.blocked.WithLabelValues(c)
+	if adder, ok := counter.(prometheus.ExemplarAdder); ok && traceID != "" {
+		adder.AddWithExemplar(1, prometheus.Labels{"trace_id": traceID})
+		return
+	}
+	counter.Inc()
+}
+
+func (p *prometheusMetrics) requestWouldBlock() { p.wouldBlock.Inc() }
+func (p *prometheusMetrics) requestAllowed()    { p.allowed.Inc() }
+
+func (p *prometheusMetrics) shadowDivergence(direction string) {
+	p.shadow.WithLabelValues(direction).Inc()
+}
+
+func (p *prometheusMetrics) setBlocklistSize(n int) { p.blocklistSize.Set(float64(n)) }
+func (p *prometheusMetrics) setTrackedIPs(n int)    { p.trackedIPs.Set(float64(n)) }
+
+func (p *prometheusMetrics) blocklistChanged(added, removed int) {
+	p.added.Add(float64(added))
+	p.removed.Add(float64(removed))
+}
+
+func (p *prometheusMetrics) setLockdown(enabled bool)     { p.lockdown.Set(boolGauge(enabled)) }
+func (p *prometheusMetrics) setBlocklistStale(stale bool) { p.stale.Set(boolGauge(stale)) }
+
+func (p *prometheusMetrics) observeReload(d time.Duration)   { p.reload.Observe(d.Seconds()) }
+func (p *prometheusMetrics) observeDecision(d time.Duration) { p.decision.Observe(d.Seconds()) }
+
+// boolGauge returns the gauge value of a flag.
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,246 @@
+package traefik_plugin
+
+import (
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// inScope reports whether the middleware applies to requests for path. With
+// neither PathPrefixes nor PathRegex configured every path is in scope;
+// otherwise path must match one of them.
+func (m *Fail2BanMiddleware) inScope(path string) bool {
+	if len(m.pathPrefixes) == 0 && m.pathRegex == nil {
+		return true
+	}
+
+	if hasAnyPrefix(path, m.pathPrefixes) {
+		return true
+	}
+
+	return m.pathRegex != nil && m.pathRegex.MatchString(path)
+}
+
+// requestPath returns the path of req that path-scoped rules match, which is
+// normalized with NormalizePaths.
+func (m *Fail2BanMiddleware) requestPath(req *http.Request) string {
+	if !m.normalizePaths {
+		return req.URL.Path
+	}
+
+	return normalizePath(req.URL.Path)
+}
+
+// normalizePath returns p with percent-encoded unreserved characters decoded,
+// then cleaned like path.Clean, which collapses runs of slashes and resolves
+// . and .. segments. A trailing slash is kept, so prefixes like /admin/ still
+// match. req.URL.Path is already decoded once, so the escapes left are those
+// of double-encoded paths; reserved characters, such as an encoded slash, stay
+// encoded since backends don't treat them as path syntax either.
+func normalizePath(p string) string {
+	p = decodeUnreserved(p)
+	if p == "" || p[0] != '/' {
+		p = "/" + p
+	}
+
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// decodeUnreserved decodes the percent-encoded unreserved characters of s:
+// letters, digits, and -, ., _ and ~.
+func decodeUnreserved(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) {
+			if c, ok := unhexByte(s[i+1], s[i+2]); ok && isUnreserved(c) {
+				b.WriteByte(c)
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// unhexByte decodes the hex digits hi and lo.
+func unhexByte(hi, lo byte) (byte, bool) {
+	h, ok := unhex(hi)
+	if !ok {
+		return 0, false
+	}
+	l, ok := unhex(lo)
+	if !ok {
+		return 0, false
+	}
+	return h<<4 | l, true
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// isUnreserved reports whether c is an unreserved URI character (RFC 3986).
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// excluded reports whether path is one of ExcludePaths.
+func (m *Fail2BanMiddleware) excluded(path string) bool {
+	_, ok := m.excludePaths[path]
+	return ok
+}
+
+// pathSet returns the set of paths, or nil for none.
+func pathSet(paths []string) map[string]struct{} {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	set := make(map[string]struct{}, len(paths))
+	for _, path := range paths {
+		set[path] = struct{}{}
+	}
+	return set
+}
+
+// bypassed reports whether req carries the bypass header with the configured
+// value, or a valid bypass token. The value is compared in constant time so it
+// can't be guessed byte by byte.
+func (m *Fail2BanMiddleware) bypassed(req *http.Request) bool {
+	if m.bypassHeader != "" {
+		value := req.Header.Get(m.bypassHeader)
+		if subtle.ConstantTimeCompare([]byte(value), []byte(m.bypassHeaderValue)) == 1 {
+			return true
+		}
+	}
+
+	return m.bypassedByToken(req)
+}
+
+// exemptClientCert returns the verified client certificate of req if it
+// matches RequireClientCertExemption, or nil.
+func (m *Fail2BanMiddleware) exemptClientCert(req *http.Request) *x509.Certificate {
+	if (len(m.certIssuers) == 0 && len(m.certSubjects) == 0) || req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+
+	cert := req.TLS.VerifiedChains[0][0]
+	if len(m.certIssuers) > 0 && !matchesName(m.certIssuers, cert.Issuer) {
+		return nil
+	}
+	if len(m.certSubjects) > 0 && !matchesName(m.certSubjects, cert.Subject) {
+		return nil
+	}
+
+	return cert
+}
+
+// matchesName reports whether the common name or the full distinguished name
+// of name is in names.
+func matchesName(names map[string]struct{}, name pkix.Name) bool {
+	if _, ok := names[name.CommonName]; ok && name.CommonName != "" {
+		return true
+	}
+	_, ok := names[name.String()]
+	return ok
+}
+
+// stringSet returns the set of values.
+func stringSet(values []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(values))
+	for _, value := range values {
+		set[value] = struct{}{}
+	}
+
+	return set
+}
+
+// allowedUserAgent reports whether userAgent matches AllowedUserAgents.
+func (m *Fail2BanMiddleware) allowedUserAgent(userAgent string) bool {
+	if userAgent == "" {
+		return false
+	}
+	if _, ok := m.allowedUserAgents[userAgent]; ok {
+		return true
+	}
+	for _, re := range m.allowedUserAgentRegex {
+		if re.MatchString(userAgent) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseUserAgents splits AllowedUserAgents into the exact user agents and the
+// compiled regular expressions of the entries wrapped in slashes.
+func parseUserAgents(entries []string) (map[string]struct{}, []*regexp.Regexp, error) {
+	exact := make(map[string]struct{})
+	var regexes []*regexp.Regexp
+	for _, entry := range entries {
+		if len(entry) < 2 || !strings.HasPrefix(entry, "/") || !strings.HasSuffix(entry, "/") {
+			exact[entry] = struct{}{}
+			continue
+		}
+
+		re, err := regexp.Compile(entry[1 : len(entry)-1])
+		if err != nil {
+			return nil, nil, err
+		}
+		regexes = append(regexes, re)
+	}
+
+	return exact, regexes, nil
+}
+
+// audit logs an allowed request for one of the AuditPaths at info level,
+// regardless of Verbose. decision tells how the request got through.
+func (m *Fail2BanMiddleware) audit(req *http.Request, clientIP, decision string) {
+	if len(m.auditPaths) == 0 || !hasAnyPrefix(m.requestPath(req), m.auditPaths) {
+		return
+	}
+
+	m.logger.Info("Audited request", "ip", m.logIP(clientIP), "method", req.Method, "path", req.URL.Path, "decision", decision)
+}
+
+// hasAnyPrefix reports whether path starts with one of prefixes.
+func hasAnyPrefix(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// compileOptionalRegex compiles expr, returning nil for an empty expression.
+func compileOptionalRegex(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+
+	return regexp.Compile(expr)
+}
@@ -0,0 +1,72 @@
+package traefik_plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNormalizePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/", "/"},
+		{"", "/"},
+		{"admin", "/admin"},
+		{"/admin/", "/admin/"},
+		{"//admin", "/admin"},
+		{"/admin//secret", "/admin/secret"},
+		{"/./admin", "/admin"},
+		{"/admin/./secret", "/admin/secret"},
+		{"/public/../admin", "/admin"},
+		{"/../../admin", "/admin"},
+		{"/admin/x/../", "/admin/"},
+		{"/admin/.", "/admin"},
+		{"/admin/./", "/admin/"},
+		{"/admin.", "/admin."},
+		{"/admin..", "/admin.."},
+		{"/%61dmin", "/admin"},
+		{"/public/%2E%2E/admin", "/admin"},
+		{"/public/%2e./admin", "/admin"},
+		{"/admin%2Fsecret", "/admin%2Fsecret"},
+		{"/admin%5Csecret", "/admin%5Csecret"},
+		{"/admin%", "/admin%"},
+		{"/admin%2", "/admin%2"},
+		{"/admin%zz", "/admin%zz"},
+	}
+	for _, tt := range tests {
+		if got := normalizePath(tt.path); got != tt.want {
+			t.Errorf("normalizePath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+// TestRequestPath checks the paths matched for request targets, which reach
+// normalizePath decoded once.
+func TestRequestPath(t *testing.T) {
+	m := newTestMiddleware(t, nil)
+	raw := newTestMiddleware(t, func(c *Config) { c.NormalizePaths = false })
+
+	tests := []struct {
+		target  string
+		want    string
+		wantRaw string
+	}{
+		{"/admin", "/admin", "/admin"},
+		{"//admin/./x", "/admin/x", "//admin/./x"},
+		{"/public/../admin/", "/admin/", "/public/../admin/"},
+		{"/admin%2Fsecret", "/admin/secret", "/admin/secret"},
+		{"/public/%252E%252E/admin", "/admin", "/public/%2E%2E/admin"},
+		{"/admin%252Fsecret", "/admin%2Fsecret", "/admin%2Fsecret"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+		if got := m.requestPath(req); got != tt.want {
+			t.Errorf("requestPath(%q) = %q, want %q", tt.target, got, tt.want)
+		}
+		if got := raw.requestPath(req); got != tt.wantRaw {
+			t.Errorf("requestPath(%q) without NormalizePaths = %q, want %q", tt.target, got, tt.wantRaw)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package traefik_plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// defaultPauseDuration is how long POST /pause pauses enforcement without a
+// duration when PauseDuration is unset.
+const defaultPauseDuration = 30 * time.Minute
+
+// pauseRequest is the body of POST /pause.
+type pauseRequest struct {
+	// Duration is a Go duration such as "30m"; empty uses PauseDuration and
+	// zero resumes enforcement.
+	Duration string `json:"duration,omitempty"`
+}
+
+// pauseState is the response of both /pause methods.
+type pauseState struct {
+	Paused           bool       `json:"paused"`
+	Until            *time.Time `json:"until,omitempty"`
+	RemainingSeconds int64      `json:"remainingSeconds,omitempty"`
+}
+
+// pausedUntil returns when paused enforcement resumes, and whether it is
+// paused at now.
+func (m *Fail2BanMiddleware) pausedUntil(now time.Time) (time.Time, bool) {
+	until := atomic.LoadInt64(&m.pauseUntil)
+	if until == 0 || now.UnixNano() >= until {
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, until), true
+}
+
+// enforcing reports whether blocks and rate limits are enforced at now, as
+// opposed to only logged in dry-run mode or while paused.
+func (m *Fail2BanMiddleware) enforcing(now time.Time) bool {
+	if m.dryRun {
+		return false
+	}
+	_, paused := m.pausedUntil(now)
+
+	return !paused
+}
+
+// pause suspends enforcement for d from now, or resumes it when d is zero.
+func (m *Fail2BanMiddleware) pause(d time.Duration, now time.Time) {
+	var until int64
+	if d > 0 {
+		until = now.Add(d).UnixNano()
+	}
+	atomic.StoreInt64(&m.pauseUntil, until)
+}
+
+// currentPauseState returns the pause state at now for the admin API.
+func (m *Fail2BanMiddleware) currentPauseState(now time.Time) pauseState {
+	until, paused := m.pausedUntil(now)
+	if !paused {
+		return pauseState{}
+	}
+
+	until = until.UTC()
+	return pauseState{
+		Paused:           true,
+		Until:            &until,
+		RemainingSeconds: int64((until.Sub(now) + time.Second - 1) / time.Second),
+	}
+}
+
+// handlePause reports the pause state on GET and pauses or resumes
+// enforcement on POST. While paused, requests that would be blocked are logged
+// and served as in dry-run mode, until the pause runs out.
+func (m *Fail2BanMiddleware) handlePause(rw http.ResponseWriter, req *http.Request) {
+	now := m.nowFunc()
+
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var body pauseRequest
+		if err := json.NewDecoder(http.MaxBytesReader(rw, req.Body, maxAdminBodyBytes)).Decode(&body); err != nil {
+			writeJSONError(rw, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+
+		d := m.pauseDuration
+		if body.Duration != "" {
+			var err error
+			d, err = time.ParseDuration(body.Duration)
+			if err != nil || d < 0 {
+				writeJSONError(rw, http.StatusBadRequest, fmt.Sprintf("invalid duration %q", body.Duration))
+				return
+			}
+		}
+
+		m.pause(d, now)
+		if d > 0 {
+			m.logger.Warn("Paused enforcement via admin API", "duration", d)
+		} else {
+			m.logger.Info("Resumed enforcement via admin API")
+		}
+	default:
+		writeMethodNotAllowed(rw, http.MethodGet+", "+http.MethodPost)
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, m.currentPauseState(now))
+}
@@ -0,0 +1,88 @@
+package traefik_plugin
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testBlocklist is the blocklist newTestMiddleware starts with.
+const testBlocklist = "192.0.2.1 # exact\n198.51.100.0/24\n# comment\n"
+
+// newTestMiddleware builds the middleware through New with testBlocklist in a
+// temporary file, blocking private ranges like any other, after mod adjusts
+// the configuration. The next handler answers 401 on /fail and "ok" otherwise.
+func newTestMiddleware(t testing.TB, mod func(*Config)) *Fail2BanMiddleware {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := os.WriteFile(path, []byte(testBlocklist), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := CreateConfig()
+	config.BlocklistPath = path
+	config.SkipPrivateRanges = false
+	if mod != nil {
+		mod(config)
+	}
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/fail" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = rw.Write([]byte("ok"))
+	})
+	handler, err := New(context.Background(), next, config, "test")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	m := handler.(*Fail2BanMiddleware)
+	t.Cleanup(func() { _ = m.Close() })
+
+	return m
+}
+
+// serve sends a GET for path from remoteAddr, with headers, through h.
+func serve(h http.Handler, remoteAddr, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = remoteAddr
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestNewAllowsAndBlocks(t *testing.T) {
+	m := newTestMiddleware(t, nil)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		wantCode   int
+	}{
+		{"allowed", "203.0.113.7:4321", http.StatusOK},
+		{"exact entry", "192.0.2.1:4321", http.StatusForbidden},
+		{"CIDR entry", "198.51.100.42:4321", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(m, tt.remoteAddr, "/", nil)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantCode == http.StatusOK && rec.Body.String() != "ok" {
+				t.Errorf("body = %q, want the next handler's %q", rec.Body.String(), "ok")
+			}
+		})
+	}
+}
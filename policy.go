@@ -0,0 +1,160 @@
+package traefik_plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Policy applies its own blocklists and thresholds to the requests for some
+// hosts, so one middleware can serve domains with different needs. Unset
+// fields take the value of the top-level configuration. Each policy keeps
+// its own bans, failure counts and lists.
+type Policy struct {
+	Name string `json:"name"`
+	// Hosts are the request hosts the policy applies to, matched without
+	// their port and case-insensitively. "*.example.com" matches the
+	// subdomains of example.com but not example.com itself.
+	Hosts []string `json:"hosts"`
+
+	BlocklistPath  string        `json:"blocklistPath"`
+	BlocklistPaths []string      `json:"blocklistPaths"`
+	MaxRequests    int           `json:"maxRequests"`
+	FindTime       time.Duration `json:"findTime"`
+	BanTime        time.Duration `json:"banTime"`
+}
+
+// hostPolicy is a loaded Policy.
+type hostPolicy struct {
+	name  string
+	hosts []string // lowercased
+	m     *Fail2BanMiddleware
+}
+
+// validatePolicies checks the Policies configuration: each named uniquely
+// and with hosts, no host claimed by two policies.
+func validatePolicies(policies []Policy) error {
+	names := make(map[string]struct{}, len(policies))
+	hosts := make(map[string]string)
+	for i, p := range policies {
+		if p.Name == "" {
+			return fmt.Errorf("policies[%d]: name is required", i)
+		}
+		if _, ok := names[p.Name]; ok {
+			return fmt.Errorf("policies[%d]: duplicate name %q", i, p.Name)
+		}
+		names[p.Name] = struct{}{}
+
+		if len(p.Hosts) == 0 {
+			return fmt.Errorf("policies[%d]: hosts are required", i)
+		}
+		for _, host := range p.Hosts {
+			host = strings.ToLower(strings.TrimSpace(host))
+			if host == "" || host == "*." {
+				return fmt.Errorf("policies[%d]: empty host", i)
+			}
+			if other, ok := hosts[host]; ok {
+				return fmt.Errorf("policies[%d]: host %q is already in policy %q", i, host, other)
+			}
+			hosts[host] = p.Name
+		}
+	}
+
+	return nil
+}
+
+// policyConfig returns the configuration of the middleware serving p: config
+// with the fields p sets replaced. The admin API, update socket, state file,
+// Redis sharing and configPath belong to the top-level middleware, and the
+// block log is shared with it.
+func policyConfig(config *Config, p Policy) *Config {
+	c := *config
+	c.Policies = nil
+	c.AdminListenAddr = ""
+	c.AdminToken = ""
+	c.ConfigPath = ""
+	c.UpdateSocketPath = ""
+	c.StatePath = ""
+	c.RedisURL = ""
+	c.BlockLogFormat = ""
+	c.BlockLogPath = ""
+
+	if p.BlocklistPath != "" || len(p.BlocklistPaths) > 0 {
+		c.BlocklistPath = p.BlocklistPath
+		c.BlocklistPaths = p.BlocklistPaths
+	}
+	if p.MaxRequests > 0 {
+		c.MaxRequests = p.MaxRequests
+	}
+	if p.FindTime > 0 {
+		c.FindTime = p.FindTime
+	}
+	if p.BanTime > 0 {
+		c.BanTime = p.BanTime
+	}
+
+	return &c
+}
+
+// newPolicies loads the Policies of config, each as a middleware named after
+// name and the policy.
+func (m *Fail2BanMiddleware) newPolicies(ctx context.Context, next http.Handler, config *Config, name string) error {
+	for i, p := range config.Policies {
+		handler, err := New(ctx, next, policyConfig(config, p), name+"@"+p.Name)
+		if err != nil {
+			return fmt.Errorf("policies[%d]: %w", i, err)
+		}
+		pm := handler.(*Fail2BanMiddleware)
+		pm.blockLog = m.blockLog
+
+		hosts := make([]string, 0, len(p.Hosts))
+		for _, host := range p.Hosts {
+			hosts = append(hosts, strings.ToLower(strings.TrimSpace(host)))
+		}
+		m.policies = append(m.policies, hostPolicy{name: p.Name, hosts: hosts, m: pm})
+	}
+
+	return nil
+}
+
+// policyFor returns the middleware of the policy for the host of req, or nil
+// when the top-level configuration applies. Requests without a Host header
+// are matched by their TLS server name.
+func (m *Fail2BanMiddleware) policyFor(req *http.Request) *Fail2BanMiddleware {
+	if len(m.policies) == 0 {
+		return nil
+	}
+
+	host := req.Host
+	if host == "" && req.TLS != nil {
+		host = req.TLS.ServerName
+	}
+	host = strings.ToLower(strings.TrimSuffix(hostFromAddr(host), "."))
+
+	for _, p := range m.policies {
+		for _, pattern := range p.hosts {
+			if pattern == host || (strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:])) {
+				return p.m
+			}
+		}
+	}
+
+	return nil
+}
+
+// closePolicies closes the middlewares of the policies.
+func (m *Fail2BanMiddleware) closePolicies() error {
+	var errs []error
+	for _, p := range m.policies {
+		// The block log is closed by m.
+		p.m.blockLog = nil
+		if err := p.m.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("policy %s: %w", p.name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
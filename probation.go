@@ -0,0 +1,119 @@
+package traefik_plugin
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ProbationStats counts the transitions of automatic bans through probation
+// since New: bans lifted early onto probation, probations that ran out
+// cleanly, and probations ended by a new ban.
+type ProbationStats struct {
+	OnProbation int    `json:"onProbation"`
+	Probations  uint64 `json:"probations"`
+	Cleared     uint64 `json:"cleared"`
+	Rebanned    uint64 `json:"rebanned"`
+}
+
+// probationCounts holds the ProbationStats counters. It is guarded by m.mu.
+type probationCounts struct {
+	probations uint64
+	cleared    uint64
+	rebanned   uint64
+}
+
+// onProbation reports whether the IP or range key is on probation at now. The
+// caller must hold m.mu.
+func (m *Fail2BanMiddleware) onProbation(key string, now time.Time) bool {
+	until, ok := m.probation[m.storedKey(key)]
+	return ok && now.Before(until)
+}
+
+// sweepProbation periodically moves automatic bans that have blocked nothing
+// for probationAfter onto probation for the rest of their term, and clears
+// the probations that ran out. It returns once m.ctx is cancelled.
+func (m *Fail2BanMiddleware) sweepProbation() {
+	interval := m.probationAfter / 4
+	if interval <= 0 {
+		interval = m.probationAfter
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			m.liftQuietBans(m.nowFunc())
+		}
+	}
+}
+
+// liftQuietBans lifts the automatic bans that have been quiet for
+// probationAfter at now and puts their keys on probation until the bans would
+// have ended, or for probationAfter if they were permanent.
+func (m *Fail2BanMiddleware) liftQuietBans(now time.Time) {
+	var lifted []string
+
+	m.mu.Lock()
+	for key, until := range m.probation {
+		if !now.Before(until) {
+			delete(m.probation, key)
+			m.probationCounts.cleared++
+		}
+	}
+	for key, b := range m.bans.List() {
+		if b.rule != ruleRate || b.start.IsZero() {
+			continue
+		}
+		if !b.expiry.IsZero() && !now.Before(b.expiry) {
+			continue
+		}
+		quietSince := b.start
+		if lastSeen := atomic.LoadInt64(&b.hits.lastSeen); lastSeen != 0 && lastSeen > quietSince.UnixNano() {
+			quietSince = time.Unix(0, lastSeen)
+		}
+		if now.Sub(quietSince) < m.probationAfter {
+			continue
+		}
+
+		until := b.expiry
+		if until.IsZero() {
+			until = now.Add(m.probationAfter)
+		}
+		m.bans.Delete(key)
+		m.probation[key] = until
+		m.probationCounts.probations++
+		lifted = append(lifted, key)
+	}
+	m.mu.Unlock()
+
+	if len(lifted) == 0 {
+		return
+	}
+	m.cache.purge()
+	for _, key := range lifted {
+		m.unshareBan(key)
+		m.logger.Info("Lifted quiet ban onto probation", "ip", m.logIP(key))
+	}
+}
+
+// probationStats returns the ProbationStats at now.
+func (m *Fail2BanMiddleware) probationStats(now time.Time) ProbationStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	s := ProbationStats{
+		Probations: m.probationCounts.probations,
+		Cleared:    m.probationCounts.cleared,
+		Rebanned:   m.probationCounts.rebanned,
+	}
+	for key := range m.probation {
+		if m.onProbation(key, now) {
+			s.OnProbation++
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,149 @@
+package traefik_plugin
+
+import (
+	"container/list"
+	"context"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ptrLookupTimeout bounds the reverse DNS lookup of one client IP.
+	ptrLookupTimeout = 2 * time.Second
+
+	// ptrCacheSize caps how many client IPs' PTR names are cached.
+	ptrCacheSize = 10000
+)
+
+// parsePTRPatterns compiles the BlockedPTRPatterns configuration.
+func parsePTRPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, re)
+	}
+
+	return compiled, nil
+}
+
+// ptrMatcher matches clients whose reverse DNS name matches one of
+// BlockedPTRPatterns. It is the last link of the matcher chain, so only
+// clients no other rule decided pay for the lookup.
+type ptrMatcher struct{ m *Fail2BanMiddleware }
+
+func (p ptrMatcher) Match(ip net.IP, r *http.Request) (bool, string) {
+	b, ok := p.match(ip.String(), r, p.m.nowFunc())
+	return ok, b.reason
+}
+
+func (p ptrMatcher) match(clientIP string, _ *http.Request, now time.Time) (ban, bool) {
+	for _, name := range p.m.ptrNames(clientIP, now) {
+		for _, pattern := range p.m.ptrPatterns {
+			if pattern.MatchString(name) {
+				return ban{rule: rulePTR, reason: name}, true
+			}
+		}
+	}
+
+	return ban{}, false
+}
+
+// ptrNames returns the reverse DNS names of clientIP without their trailing
+// dot, from the cache when possible. Failed lookups return no names and are
+// cached for negativeLookupTTL, successful ones for PTRCacheTTL.
+func (m *Fail2BanMiddleware) ptrNames(clientIP string, now time.Time) []string {
+	if names, ok := m.ptrCache.get(clientIP, now); ok {
+		return names
+	}
+
+	ctx, cancel := context.WithTimeout(m.ctx, ptrLookupTimeout)
+	defer cancel()
+
+	names, err := net.DefaultResolver.LookupAddr(ctx, clientIP)
+	if err != nil {
+		m.logger.Debug("Failed to look up PTR of client IP", "ip", m.logIP(clientIP), "error", err)
+		m.ptrCache.put(clientIP, nil, now.Add(negativeLookupTTL))
+		return nil
+	}
+
+	for i, name := range names {
+		names[i] = strings.TrimSuffix(name, ".")
+	}
+	m.ptrCache.put(clientIP, names, now.Add(m.ptrCache.ttl))
+
+	return names
+}
+
+// ptrCache is a fixed-size LRU of the reverse DNS names of client IPs.
+type ptrCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List // of *ptrEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type ptrEntry struct {
+	ip     string
+	names  []string
+	expiry time.Time
+}
+
+// newPTRCache returns a cache holding up to size IPs' names for ttl.
+func newPTRCache(size int, ttl time.Duration) *ptrCache {
+	return &ptrCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get returns the cached names of ip at now.
+func (c *ptrCache) get(ip string, now time.Time) ([]string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[ip]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*ptrEntry)
+	if !now.Before(entry.expiry) {
+		c.order.Remove(elem)
+		delete(c.entries, ip)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+
+	return entry.names, true
+}
+
+// put caches the names of ip until expiry, evicting the least recently used
+// entry when full.
+func (c *ptrCache) put(ip string, names []string, expiry time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[ip]; ok {
+		entry := elem.Value.(*ptrEntry)
+		entry.names = names
+		entry.expiry = expiry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	if c.order.Len() >= c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*ptrEntry).ip)
+	}
+	c.entries[ip] = c.order.PushFront(&ptrEntry{ip: ip, names: names, expiry: expiry})
+}
@@ -0,0 +1,75 @@
+package traefik_plugin
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// rateBucket is the token bucket of an IP. It holds up to rateBurst tokens and
+// refills at rateLimit per rateWindow; each request takes one token.
+type rateBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// allowRate takes a token from the bucket of clientIP at now. When the bucket
+// is empty it reports false and how long until the next token is available.
+func (m *Fail2BanMiddleware) allowRate(clientIP string, now time.Time) (bool, time.Duration) {
+	burst := float64(m.rateBurst)
+	perSecond := float64(m.rateLimit) / m.rateWindow.Seconds()
+
+	m.rateMu.Lock()
+	defer m.rateMu.Unlock()
+
+	bucket, ok := m.rateBuckets[clientIP]
+	if !ok {
+		bucket = &rateBucket{tokens: burst, updated: now}
+		m.rateBuckets[clientIP] = bucket
+	}
+	if elapsed := now.Sub(bucket.updated); elapsed > 0 {
+		bucket.tokens = math.Min(burst, bucket.tokens+elapsed.Seconds()*perSecond)
+		bucket.updated = now
+	}
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - bucket.tokens) / perSecond * float64(time.Second))
+}
+
+// rateLimited writes the response for a request over the rate limit, a 429
+// unless RuleStatusCodes says otherwise.
+func (m *Fail2BanMiddleware) rateLimited(rw http.ResponseWriter, clientIP string, retryAfter time.Duration) {
+	rw.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	m.writeBlockResponse(rw, m.ruleStatusCodes[ruleRateLimit], clientIP, "rate_limited")
+}
+
+// sweepRateBuckets periodically drops the buckets of IPs idle for long enough
+// to refill rateBurst tokens, which are full again and so equivalent to a new
+// bucket. It returns once m.ctx is cancelled.
+func (m *Fail2BanMiddleware) sweepRateBuckets() {
+	ticker := time.NewTicker(m.rateWindow)
+	defer ticker.Stop()
+
+	refill := time.Duration(float64(m.rateWindow) * float64(m.rateBurst) / float64(m.rateLimit))
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			now := m.nowFunc()
+			m.rateMu.Lock()
+			for ip, bucket := range m.rateBuckets {
+				if now.Sub(bucket.updated) >= refill {
+					delete(m.rateBuckets, ip)
+				}
+			}
+			m.rateMu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package traefik_plugin
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+// responseRecorder records the status and body size of the response a
+// handler writes through it, for the features that depend on the response:
+// counting failures toward a ban, the block log and the admin API's auth
+// failure limit. It passes Flush, Hijack and Push through to the wrapped
+// writer, so streaming responses, server-sent events and WebSocket upgrades
+// work through it. Note that Traefik's Yaegi interpreter only lets the
+// handlers compiled into Traefik see Hijack: they can't flush through it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int   // zero until the handler writes a final status
+	size   int64 // bytes of body written
+}
+
+// WriteHeader records the first final status written and forwards it.
+// Informational statuses are forwarded only, except 101 Switching Protocols,
+// which ends the response.
+func (w *responseRecorder) WriteHeader(code int) {
+	if w.status == 0 && (code >= http.StatusOK || code == http.StatusSwitchingProtocols) {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// Write forwards to the wrapped writer, recording the implicit 200 status.
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+// Flush sends any buffered data to the client, which commits the implicit 200
+// status. It does nothing if the wrapped writer can't flush.
+func (w *responseRecorder) Flush() {
+	flusher, ok := w.ResponseWriter.(http.Flusher)
+	if !ok {
+		return
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	flusher.Flush()
+}
+
+// Hijack lets the handler take over the connection, as for a WebSocket
+// upgrade, which is recorded as a 101 status unless one was written.
+func (w *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil && w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Push initiates an HTTP/2 server push if the wrapped writer supports it.
+func (w *responseRecorder) Push(target string, opts *http.PushOptions) error {
+	pusher, ok := w.ResponseWriter.(http.Pusher)
+	if !ok {
+		return http.ErrNotSupported
+	}
+	return pusher.Push(target, opts)
+}
+
+// Unwrap returns the wrapped writer, for http.ResponseController.
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// statusCode returns the recorded status, defaulting to 200 when the handler
+// wrote nothing.
+func (w *responseRecorder) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
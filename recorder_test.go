@@ -0,0 +1,79 @@
+package traefik_plugin
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// hijackableWriter is a ResponseWriter whose connection can be hijacked.
+type hijackableWriter struct {
+	*httptest.ResponseRecorder
+	conn     net.Conn
+	hijacked bool
+}
+
+func (w *hijackableWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.hijacked = true
+	return w.conn, bufio.NewReadWriter(bufio.NewReader(w.conn), bufio.NewWriter(w.conn)), nil
+}
+
+func TestResponseRecorderFlush(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := &responseRecorder{ResponseWriter: rec}
+
+	var rw http.ResponseWriter = w
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		t.Fatal("responseRecorder isn't an http.Flusher")
+	}
+	flusher.Flush()
+
+	if !rec.Flushed {
+		t.Error("Flush didn't reach the wrapped writer")
+	}
+	if got := w.statusCode(); got != http.StatusOK {
+		t.Errorf("statusCode() after Flush = %d, want %d", got, http.StatusOK)
+	}
+
+	// Through http.ResponseController, which finds the writer by Unwrap.
+	rec = httptest.NewRecorder()
+	if err := http.NewResponseController(&responseRecorder{ResponseWriter: rec}).Flush(); err != nil || !rec.Flushed {
+		t.Errorf("ResponseController.Flush() = %v, flushed %v", err, rec.Flushed)
+	}
+}
+
+func TestResponseRecorderHijack(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	inner := &hijackableWriter{ResponseRecorder: httptest.NewRecorder(), conn: server}
+	w := &responseRecorder{ResponseWriter: inner}
+
+	var rw http.ResponseWriter = w
+	hijacker, ok := rw.(http.Hijacker)
+	if !ok {
+		t.Fatal("responseRecorder isn't an http.Hijacker")
+	}
+	conn, _, err := hijacker.Hijack()
+	switch {
+	case err != nil:
+		t.Fatalf("Hijack() = %v", err)
+	case !inner.hijacked:
+		t.Fatal("Hijack didn't reach the wrapped writer")
+	case conn != server:
+		t.Error("Hijack didn't return the wrapped writer's connection")
+	}
+	if got := w.statusCode(); got != http.StatusSwitchingProtocols {
+		t.Errorf("statusCode() after Hijack = %d, want %d", got, http.StatusSwitchingProtocols)
+	}
+
+	// A writer that can't be hijacked.
+	if _, _, err := (&responseRecorder{ResponseWriter: httptest.NewRecorder()}).Hijack(); !errors.Is(err, http.ErrNotSupported) {
+		t.Errorf("Hijack() of a plain writer = %v, want %v", err, http.ErrNotSupported)
+	}
+}
@@ -0,0 +1,266 @@
+package traefik_plugin
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// redisTimeout bounds connecting to Redis and each command, so a slow
+	// Redis delays requests by at most this much.
+	redisTimeout = 500 * time.Millisecond
+
+	// redisRetryInterval is how long Redis is skipped after a failure before
+	// the next attempt to reach it.
+	redisRetryInterval = 5 * time.Second
+
+	// redisCacheSize and redisCacheTTL bound the local cache of lookups, so
+	// hot IPs don't cost a round-trip per request.
+	redisCacheSize = 10000
+	redisCacheTTL  = 2 * time.Second
+
+	// redisKeyPrefix prefixes the key of each shared ban.
+	redisKeyPrefix = "ban:"
+)
+
+// errRedisNil is returned for a nil bulk reply, i.e. a missing key.
+var errRedisNil = errors.New("redis: nil")
+
+// redisStore is the connection to the Redis server redisBanStore shares
+// dynamic bans between nodes through. Bans are stored as "ban:<ip>" with the
+// ban's remaining time as TTL and a JSON banEntry as value. While Redis is
+// unreachable the store reports no bans and the middleware falls back to its
+// local bans.
+type redisStore struct {
+	addr     string
+	username string
+	password string
+	db       int
+	tls      bool
+
+	cache *decisionCache
+
+	// mu serializes commands on the single connection.
+	mu       sync.Mutex
+	conn     net.Conn
+	reader   *bufio.Reader
+	degraded bool      // the last attempt to reach Redis failed
+	retryAt  time.Time // when to try again while degraded
+}
+
+// newRedisStore parses a redis:// or rediss:// URL of the form
+// redis://[[user]:password@]host[:port][/db]. It doesn't connect yet.
+func newRedisStore(rawURL string) (*redisStore, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &redisStore{cache: newDecisionCache(redisCacheSize, redisCacheTTL)}
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		s.tls = true
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+
+	s.addr = u.Host
+	if u.Port() == "" {
+		s.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+
+	if u.User != nil {
+		s.username = u.User.Username()
+		s.password, _ = u.User.Password()
+	}
+
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		s.db, err = strconv.Atoi(db)
+		if err != nil {
+			return nil, fmt.Errorf("invalid database %q", db)
+		}
+	}
+
+	return s, nil
+}
+
+// redisDo runs a command, connecting first if needed. While Redis is degraded
+// commands fail immediately until redisRetryInterval has passed. The first
+// failure and the recovery are logged.
+func (m *Fail2BanMiddleware) redisDo(args ...string) (interface{}, error) {
+	s := m.redis
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.degraded && m.nowFunc().Before(s.retryAt) {
+		return nil, errors.New("redis unavailable")
+	}
+
+	reply, err := s.do(args)
+	if err != nil && !errors.Is(err, errRedisNil) {
+		if _, isReplyErr := err.(redisError); !isReplyErr {
+			s.close()
+			if !s.degraded {
+				m.logger.Warn("Redis unavailable, falling back to local bans", "addr", s.addr, "error", err)
+			}
+			s.degraded = true
+			s.retryAt = m.nowFunc().Add(redisRetryInterval)
+		}
+		return nil, err
+	}
+
+	if s.degraded {
+		m.logger.Info("Redis available again", "addr", s.addr)
+		s.degraded = false
+	}
+
+	return reply, err
+}
+
+// redisError is an error reply from Redis.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// do sends a command and reads its reply. The caller must hold s.mu.
+func (s *redisStore) do(args []string) (interface{}, error) {
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.roundTrip(args)
+}
+
+// connect dials Redis, authenticates and selects the database. The caller
+// must hold s.mu.
+func (s *redisStore) connect() error {
+	dialer := &net.Dialer{Timeout: redisTimeout}
+	var conn net.Conn
+	var err error
+	if s.tls {
+		host, _, _ := net.SplitHostPort(s.addr)
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.addr, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", s.addr)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.conn = conn
+	s.reader = bufio.NewReader(conn)
+
+	var setup [][]string
+	if s.password != "" {
+		if s.username != "" {
+			setup = append(setup, []string{"AUTH", s.username, s.password})
+		} else {
+			setup = append(setup, []string{"AUTH", s.password})
+		}
+	}
+	if s.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(s.db)})
+	}
+
+	for _, args := range setup {
+		if _, err := s.roundTrip(args); err != nil {
+			s.close()
+			return fmt.Errorf("%s: %w", args[0], err)
+		}
+	}
+
+	return nil
+}
+
+// close drops the connection. The caller must hold s.mu.
+func (s *redisStore) close() {
+	if s.conn != nil {
+		_ = s.conn.Close()
+		s.conn = nil
+		s.reader = nil
+	}
+}
+
+// roundTrip writes args as a RESP array and reads the reply. The caller must
+// hold s.mu.
+func (s *redisStore) roundTrip(args []string) (interface{}, error) {
+	if err := s.conn.SetDeadline(time.Now().Add(redisTimeout)); err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(s.conn, b.String()); err != nil {
+		return nil, err
+	}
+
+	return s.readReply()
+}
+
+// readReply reads one RESP reply. Simple strings and bulk strings are returned
+// as string, integers as int64, arrays as []interface{} and a nil bulk string
+// as errRedisNil.
+func (s *redisStore) readReply() (interface{}, error) {
+	line, err := s.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("invalid reply %q", line)
+	}
+	kind, payload := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, redisError(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bulk length %q", payload)
+		}
+		if n < 0 {
+			return nil, errRedisNil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(s.reader, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(payload)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid array length %q", payload)
+		}
+		items := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			item, err := s.readReply()
+			if err != nil && !errors.Is(err, errRedisNil) {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unsupported reply type %q", kind)
+	}
+}
@@ -0,0 +1,283 @@
+package traefik_plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errRestartRequired is returned by reloadConfig for changes it can't apply
+// at runtime.
+var errRestartRequired = errors.New("changes require a restart")
+
+// reloadableFields are the settings, by JSON name, that POST /reload-config
+// applies without a restart.
+var reloadableFields = map[string]struct{}{
+	"maxRequests":    {},
+	"scoreThreshold": {},
+	"findTime":       {},
+	"banTime":        {},
+	"baseBanTime":    {},
+	"maxBanTime":     {},
+	"resetAfter":     {},
+	"reloadInterval": {},
+	"blocklistPath":  {},
+	"blocklistPaths": {},
+	"allowlistPath":  {},
+}
+
+// tunables are the settings derived from reloadableFields.
+type tunables struct {
+	maxRequests    int // failure score above which an IP is banned
+	findTime       time.Duration
+	banTime        time.Duration
+	baseBanTime    time.Duration
+	maxBanTime     time.Duration
+	resetAfter     time.Duration
+	reloadInterval time.Duration
+	blocklistPaths []string
+	allowlistPath  string
+}
+
+// newTunables returns the tunables of config.
+func newTunables(config *Config) *tunables {
+	t := &tunables{
+		maxRequests:    config.MaxRequests,
+		findTime:       config.FindTime,
+		banTime:        config.BanTime,
+		baseBanTime:    config.BaseBanTime,
+		maxBanTime:     config.MaxBanTime,
+		resetAfter:     config.ResetAfter,
+		reloadInterval: config.ReloadInterval,
+		allowlistPath:  config.AllowlistPath,
+	}
+	if config.ScoreThreshold > 0 {
+		t.maxRequests = config.ScoreThreshold
+	}
+
+	for _, path := range append([]string{config.BlocklistPath}, config.BlocklistPaths...) {
+		if path != "" {
+			t.blocklistPaths = append(t.blocklistPaths, path)
+		}
+	}
+	if config.BlocklistEnv != "" {
+		t.blocklistPaths = append(t.blocklistPaths, envSourcePrefix+config.BlocklistEnv)
+	}
+
+	return t
+}
+
+// settings returns the current tunables.
+func (m *Fail2BanMiddleware) settings() *tunables {
+	return m.tuned.Load().(*tunables)
+}
+
+// configReloadResult is the response of POST /reload-config.
+type configReloadResult struct {
+	// Changed lists the settings that changed, by JSON name.
+	Changed []string `json:"changed"`
+}
+
+// handleReloadConfig re-reads ConfigPath and applies the settings that
+// changed, or rejects the whole file with 409 Conflict if any of them needs
+// a restart.
+func (m *Fail2BanMiddleware) handleReloadConfig(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeMethodNotAllowed(rw, http.MethodPost)
+		return
+	}
+
+	if m.baseConfig.ConfigPath == "" {
+		writeJSONError(rw, http.StatusNotFound, "configPath is not set")
+		return
+	}
+
+	changed, err := m.reloadConfig()
+	switch {
+	case errors.Is(err, errRestartRequired):
+		writeJSONError(rw, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		writeJSONError(rw, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, configReloadResult{Changed: changed})
+}
+
+// reloadConfig applies the overrides in ConfigPath to the configuration the
+// middleware was created with, and returns the settings that changed since
+// the previous reload. Nothing is applied when the result is invalid or
+// changes anything but reloadableFields. The lists are reloaded when their
+// paths change.
+func (m *Fail2BanMiddleware) reloadConfig() ([]string, error) {
+	m.configMu.Lock()
+	defer m.configMu.Unlock()
+
+	next, err := overrideConfig(m.baseConfig, m.baseConfig.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := next.Validate(); err != nil {
+		return nil, err
+	}
+
+	changed, err := configChanges(m.config, next)
+	if err != nil || len(changed) == 0 {
+		return []string{}, err
+	}
+
+	previous := m.settings()
+	settings := newTunables(next)
+	if previous.allowlistPath != "" && settings.allowlistPath == "" && m.inLockdown() {
+		return nil, errors.New("allowlistPath cannot be removed during a lockdown")
+	}
+
+	m.tuned.Store(settings)
+	m.config = next
+	m.logger.Info("Reloaded configuration", "changed", changed)
+
+	if previous.allowlistPath != settings.allowlistPath || !reflect.DeepEqual(previous.blocklistPaths, settings.blocklistPaths) {
+		if settings.allowlistPath == "" {
+			m.allowlist.Store(&ipList{})
+			m.cache.purge()
+		}
+		m.reloadLists()
+	}
+
+	return changed, nil
+}
+
+// configChanges returns the JSON names of the settings that differ between
+// current and next, sorted, or errRestartRequired if any of them can't be
+// applied at runtime. Automatic bans and periodic reloads can be retuned but
+// not turned on or off.
+func configChanges(current, next *Config) ([]string, error) {
+	before, err := configFields(current)
+	if err != nil {
+		return nil, err
+	}
+	after, err := configFields(next)
+	if err != nil {
+		return nil, err
+	}
+
+	var changed, unsafe []string
+	for name, value := range after {
+		if bytes.Equal(before[name], value) {
+			continue
+		}
+		if _, ok := reloadableFields[name]; ok {
+			changed = append(changed, name)
+		} else {
+			unsafe = append(unsafe, name)
+		}
+	}
+	sort.Strings(changed)
+	sort.Strings(unsafe)
+
+	switch {
+	case len(unsafe) > 0:
+		return nil, fmt.Errorf("%w: %s", errRestartRequired, strings.Join(unsafe, ", "))
+	case (current.MaxRequests > 0 || current.ScoreThreshold > 0) != (next.MaxRequests > 0 || next.ScoreThreshold > 0),
+		(current.ScoreThreshold > 0) != (next.ScoreThreshold > 0):
+		return nil, fmt.Errorf("%w: switching automatic bans on, off or between maxRequests and scoreThreshold", errRestartRequired)
+	case (current.ReloadInterval > 0) != (next.ReloadInterval > 0):
+		return nil, fmt.Errorf("%w: switching periodic reloads on or off", errRestartRequired)
+	}
+
+	return changed, nil
+}
+
+// overrideConfig returns base with the settings in the JSON object at path
+// replacing its own. Durations are nanoseconds or Go duration strings such as
+// "10m". A missing file overrides nothing.
+func overrideConfig(base *Config, path string) (*Config, error) {
+	fields, err := configFields(base)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	if len(bytes.TrimSpace(data)) > 0 {
+		var overrides map[string]json.RawMessage
+		if err := json.Unmarshal(data, &overrides); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		durations := durationFields()
+		for name, value := range overrides {
+			if _, ok := fields[name]; !ok {
+				return nil, fmt.Errorf("%s: unknown setting %q", path, name)
+			}
+			if _, ok := durations[name]; ok && bytes.HasPrefix(value, []byte(`"`)) {
+				var s string
+				if err := json.Unmarshal(value, &s); err != nil {
+					return nil, fmt.Errorf("%s: %s: %w", path, name, err)
+				}
+				d, err := time.ParseDuration(s)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %s: %w", path, name, err)
+				}
+				value = json.RawMessage(strconv.FormatInt(int64(d), 10))
+			}
+			fields[name] = value
+		}
+	}
+
+	merged, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	next := &Config{}
+	if err := json.Unmarshal(merged, next); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return next, nil
+}
+
+// configFields returns the settings of config by JSON name.
+func configFields(config *Config) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+// durationFields returns the JSON names of the time.Duration settings of
+// Config.
+func durationFields() map[string]struct{} {
+	durationType := reflect.TypeOf(time.Duration(0))
+	configType := reflect.TypeOf(Config{})
+
+	names := make(map[string]struct{})
+	for i := 0; i < configType.NumField(); i++ {
+		field := configType.Field(i)
+		if field.Type == durationType {
+			names[strings.Split(field.Tag.Get("json"), ",")[0]] = struct{}{}
+		}
+	}
+
+	return names
+}
@@ -0,0 +1,181 @@
+package traefik_plugin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// adminPost sends body to the admin handler h.
+func adminPost(h http.HandlerFunc, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+
+	return rec
+}
+
+// TestReloadKeepsConfigOverrides checks that a blocklist reload keeps the
+// settings applied from ConfigPath by POST /reload-config.
+func TestReloadKeepsConfigOverrides(t *testing.T) {
+	var blocklistPath string
+	m := newTestMiddleware(t, func(c *Config) {
+		blocklistPath = c.BlocklistPath
+		c.MaxRequests = 10
+		c.AdminListenAddr = "127.0.0.1:0"
+		c.AdminToken = "test-admin-token"
+		c.ConfigPath = filepath.Join(filepath.Dir(c.BlocklistPath), "overrides.json")
+		if err := os.WriteFile(c.ConfigPath, []byte(`{"maxRequests": 2, "banTime": "1h"}`), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	if rec := adminPost(m.handleReloadConfig, ""); rec.Code != http.StatusOK {
+		t.Fatalf("POST /reload-config: status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	if err := os.WriteFile(blocklistPath, []byte(testBlocklist+"192.0.2.99\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.reloadBlocklist(); err != nil {
+		t.Fatalf("reloadBlocklist: %v", err)
+	}
+	if rec := serve(m, "192.0.2.99:4321", "/", nil); rec.Code != http.StatusForbidden {
+		t.Fatalf("entry added by the reload: status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	if s := m.settings(); s.maxRequests != 2 || s.banTime != time.Hour {
+		t.Errorf("settings after the reload: maxRequests %d, banTime %s, want the overrides 2, 1h", s.maxRequests, s.banTime.String())
+	}
+	for i := 0; i < 3; i++ {
+		serve(m, "203.0.113.7:4321", "/fail", nil)
+	}
+	if rec := serve(m, "203.0.113.7:4321", "/", nil); rec.Code != http.StatusForbidden {
+		t.Errorf("client over the overridden maxRequests: status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
+
+// TestReloadKeepsDynamicOverrides checks that blocklist reloads keep the
+// blocklisted IPs lifted and temporarily allowed through the admin API while
+// the source that listed them still does, and drop lifts of entries removed
+// from it.
+func TestReloadKeepsDynamicOverrides(t *testing.T) {
+	var blocklistPath string
+	m := newTestMiddleware(t, func(c *Config) { blocklistPath = c.BlocklistPath })
+	reload := func(list string) {
+		t.Helper()
+		if err := os.WriteFile(blocklistPath, []byte(list), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.reloadBlocklist(); err != nil {
+			t.Fatalf("reloadBlocklist: %v", err)
+		}
+	}
+	status := func(ip string) int { return serve(m, ip+":4321", "/", nil).Code }
+
+	if rec := adminPost(m.handleUnban, `{"ip": "192.0.2.1"}`); rec.Code != http.StatusNoContent {
+		t.Fatalf("POST /unban: status = %d, body %s", rec.Code, rec.Body)
+	}
+	if rec := adminPost(m.handleAllow, `{"ip": "198.51.100.42", "duration": "1h"}`); rec.Code != http.StatusOK {
+		t.Fatalf("POST /allow: status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	reload(testBlocklist + "192.0.2.99\n")
+	if got := status("192.0.2.99"); got != http.StatusForbidden {
+		t.Fatalf("entry added by the reload: status = %d, want %d", got, http.StatusForbidden)
+	}
+	if got := status("192.0.2.1"); got != http.StatusOK {
+		t.Errorf("lifted IP still listed: status = %d, want %d", got, http.StatusOK)
+	}
+	if got := status("198.51.100.42"); got != http.StatusOK {
+		t.Errorf("temporarily allowed IP: status = %d, want %d", got, http.StatusOK)
+	}
+
+	// Removing the entry ends the lift, so listing it again blocks anew.
+	reload("198.51.100.0/24\n")
+	reload(testBlocklist)
+	if got := status("192.0.2.1"); got != http.StatusForbidden {
+		t.Errorf("lifted IP listed again: status = %d, want %d", got, http.StatusForbidden)
+	}
+	if got := status("198.51.100.42"); got != http.StatusOK {
+		t.Errorf("temporarily allowed IP: status = %d, want %d", got, http.StatusOK)
+	}
+}
+
+// TestUnbanLiftsOnlyItsEntries checks that POST /unban lifts only the
+// blocklist entries listing the IP when it is made, so a range listed later by
+// another source and the other matchers still block the IP, and that a lift
+// with a duration runs out even while the blocklist stays the same.
+func TestUnbanLiftsOnlyItsEntries(t *testing.T) {
+	var otherPath string
+	m := newTestMiddleware(t, func(c *Config) {
+		otherPath = filepath.Join(filepath.Dir(c.BlocklistPath), "other.txt")
+		if err := os.WriteFile(otherPath, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		c.BlocklistPaths = []string{otherPath}
+	})
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	m.nowFunc = func() time.Time { return now }
+	reloadOther := func(list string) {
+		t.Helper()
+		if err := os.WriteFile(otherPath, []byte(list), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.reloadBlocklist(); err != nil {
+			t.Fatalf("reloadBlocklist: %v", err)
+		}
+	}
+	status := func(ip string) int { return serve(m, ip+":4321", "/", nil).Code }
+
+	if rec := adminPost(m.handleUnban, `{"ip": "198.51.100.42", "duration": "1h"}`); rec.Code != http.StatusNoContent {
+		t.Fatalf("POST /unban: status = %d, body %s", rec.Code, rec.Body)
+	}
+	if got := status("198.51.100.42"); got != http.StatusOK {
+		t.Fatalf("lifted IP: status = %d, want %d", got, http.StatusOK)
+	}
+	if got := status("198.51.100.43"); got != http.StatusForbidden {
+		t.Errorf("other IP of the lifted range: status = %d, want %d", got, http.StatusForbidden)
+	}
+
+	reloadOther("198.51.0.0/16\n")
+	if got := status("198.51.100.42"); got != http.StatusForbidden {
+		t.Errorf("lifted IP in a range of another source: status = %d, want %d", got, http.StatusForbidden)
+	}
+	for _, v := range m.check("198.51.100.42", now).Checks {
+		if v.Check == "blocklistCIDR" && (!v.Matched || v.Key != "198.51.0.0/16") {
+			t.Errorf("check verdict %+v, want a match on 198.51.0.0/16", v)
+		}
+	}
+
+	reloadOther("")
+	if got := status("198.51.100.42"); got != http.StatusOK {
+		t.Fatalf("lifted IP once the other range is gone: status = %d, want %d", got, http.StatusOK)
+	}
+	m.geoIP = fakeGeoDB{"198.51.100.42": "XX"}
+	m.blockedCountries = map[string]struct{}{"XX": {}}
+	matchers, err := m.newMatchers(CreateConfig())
+	if err != nil {
+		t.Fatalf("newMatchers: %v", err)
+	}
+	if b, blocked, _ := m.isBlocked("198.51.100.42", nil, now, matchers); !blocked || b.rule != ruleGeo {
+		t.Errorf("lifted IP in a blocked country: blocked = %v, rule %q, want the %q rule", blocked, b.rule, ruleGeo)
+	}
+
+	now = now.Add(time.Hour)
+	if err := m.reloadBlocklist(); err != nil {
+		t.Fatalf("reloadBlocklist: %v", err)
+	}
+	m.mu.RLock()
+	lifts := len(m.unbanned)
+	m.mu.RUnlock()
+	if lifts != 0 {
+		t.Errorf("%d lifts kept past their duration, want none", lifts)
+	}
+	if got := status("198.51.100.42"); got != http.StatusForbidden {
+		t.Errorf("lift run out: status = %d, want %d", got, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,245 @@
+package traefik_plugin
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// contentTypeJSON selects JSON block responses.
+const contentTypeJSON = "application/json"
+
+// blockResponse is the JSON body written for blocked requests.
+type blockResponse struct {
+	Error  string `json:"error"`
+	Reason string `json:"reason"`
+	IP     string `json:"ip"`
+}
+
+// blockPage holds the fields available to the block template.
+type blockPage struct {
+	IP         string
+	Reason     string
+	RetryAfter int // seconds, zero for permanent blocks
+}
+
+// loadBlockTemplate parses the block template at path, returning nil for an
+// empty path. The template is also rendered once with sample data so that
+// references to unknown fields fail at startup instead of on requests.
+func loadBlockTemplate(path string) (*template.Template, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := template.New("block").Parse(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tmpl.Execute(io.Discard, blockPage{IP: "192.0.2.1", Reason: "example", RetryAfter: 60}); err != nil {
+		return nil, err
+	}
+
+	return tmpl, nil
+}
+
+// respond is the respond block action: it writes the block response for
+// clientIP. Soft bans are redirected to the challenge page. Other bans get the
+// status from banStatus, auth bans the WWW-Authenticate challenge of
+// AuthBanWWWAuthenticate, and those with an expiry a Retry-After header, after
+// the tarpit delay. With EmitBlockCacheHeaders the headers telling edge caches
+// to drop the source are added too.
+func (m *Fail2BanMiddleware) respond(rw http.ResponseWriter, req *http.Request, clientIP string, b ban, now time.Time) {
+	if b.soft && m.challengeURL != nil {
+		http.Redirect(rw, req, m.challengeRedirect(req), http.StatusFound)
+		return
+	}
+
+	if m.tarpitDelay > 0 && !m.tarpit(req) {
+		return
+	}
+
+	if m.blockSignalHeader != "" {
+		rw.Header().Set("Cache-Control", "no-store")
+		rw.Header().Set(m.blockSignalHeader, "true")
+	}
+
+	status := m.banStatus(b)
+	if b.auth && m.authBanChallenge != "" {
+		rw.Header().Set("WWW-Authenticate", m.authBanChallenge)
+	}
+	var retryAfter int
+	if !b.expiry.IsZero() {
+		retryAfter = int(math.Ceil(b.expiry.Sub(now).Seconds()))
+		rw.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	}
+
+	if m.blockTemplate != nil {
+		m.writeBlockPage(rw, status, blockPage{IP: clientIP, Reason: b.reason, RetryAfter: retryAfter})
+		return
+	}
+	if body := m.blockBody.Load().(*blockBody); body.data != nil {
+		rw.Header().Set("Content-Type", body.contentType)
+		rw.Header().Set("X-Content-Type-Options", "nosniff")
+		rw.WriteHeader(status)
+		_, _ = rw.Write(body.data)
+		return
+	}
+
+	m.writeBlockResponse(rw, status, clientIP, "ip_blocked")
+}
+
+// blockBody is the loaded content of BlockBodyFile.
+type blockBody struct {
+	data        []byte // nil while the file couldn't be loaded
+	contentType string
+	modTime     time.Time
+	// failed tells that the latest load failed, so the failure is only
+	// logged once until the file loads again.
+	failed bool
+}
+
+// loadBlockBody loads BlockBodyFile, unless it is unchanged since it was last
+// loaded. A file that can't be read falls back to BlockMessage.
+func (m *Fail2BanMiddleware) loadBlockBody() {
+	current := m.blockBody.Load().(*blockBody)
+
+	info, err := os.Stat(m.blockBodyFile)
+	if err == nil && current.data != nil && info.ModTime().Equal(current.modTime) && info.Size() == int64(len(current.data)) {
+		return
+	}
+	var data []byte
+	if err == nil {
+		data, err = os.ReadFile(m.blockBodyFile)
+	}
+	if err != nil {
+		if !current.failed {
+			m.logger.Warn("Failed to load block body file, serving blockMessage", "path", m.blockBodyFile, "error", err)
+		}
+		m.blockBody.Store(&blockBody{failed: true})
+		return
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(m.blockBodyFile))
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	m.blockBody.Store(&blockBody{data: data, contentType: contentType, modTime: info.ModTime()})
+	if current.failed {
+		m.logger.Info("Loaded block body file", "path", m.blockBodyFile)
+	}
+}
+
+// tarpit waits tarpitDelay before a block response is written. It reports
+// false when the client went away meanwhile, so there is nothing to write.
+// Shutdown waits for the delay, unless it times out and cuts the delay short,
+// and once the middleware is draining no new delays are started.
+func (m *Fail2BanMiddleware) tarpit(req *http.Request) bool {
+	if !m.track() {
+		return true
+	}
+	defer m.inflight.Done()
+
+	timer := time.NewTimer(m.tarpitDelay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-m.ctx.Done():
+	case <-req.Context().Done():
+		return false
+	}
+
+	return true
+}
+
+// banStatus returns the status of block responses for b: authBanStatusCode
+// for auth bans when set, the one configured for its rule, otherwise
+// blockStatusCode for temporary bans and 403 for permanent blocks.
+func (m *Fail2BanMiddleware) banStatus(b ban) int {
+	if b.auth && m.authBanStatusCode != 0 {
+		return m.authBanStatusCode
+	}
+	if status, ok := m.ruleStatusCodes[b.rule]; ok {
+		return status
+	}
+	if !b.expiry.IsZero() {
+		return m.blockStatusCode
+	}
+
+	return http.StatusForbidden
+}
+
+// writeBlockPage renders the block template with status. The page is rendered
+// before anything is written, so a render error can still fall back to the
+// plain block message.
+func (m *Fail2BanMiddleware) writeBlockPage(rw http.ResponseWriter, status int, page blockPage) {
+	var buf bytes.Buffer
+	if err := m.blockTemplate.Execute(&buf, page); err != nil {
+		m.logger.Error("Failed to render block template", "error", err)
+		http.Error(rw, m.blockMessage, status)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	rw.WriteHeader(status)
+	_, _ = buf.WriteTo(rw)
+}
+
+// challengeRedirect returns the challenge URL carrying the request URI of req
+// in its "return" query parameter.
+func (m *Fail2BanMiddleware) challengeRedirect(req *http.Request) string {
+	target := *m.challengeURL
+	query := target.Query()
+	query.Set("return", req.URL.RequestURI())
+	target.RawQuery = query.Encode()
+
+	return target.String()
+}
+
+// writeBlockResponse writes status and the configured body exactly once. reason
+// is the machine-readable reason of JSON bodies.
+func (m *Fail2BanMiddleware) writeBlockResponse(rw http.ResponseWriter, status int, clientIP, reason string) {
+	if m.responseContentType != contentTypeJSON {
+		http.Error(rw, m.blockMessage, status)
+		return
+	}
+
+	// Marshalling the body before touching the header means a failure can
+	// still fall back to plain text.
+	body, err := json.Marshal(blockResponse{
+		Error:  errorCode(status),
+		Reason: reason,
+		IP:     clientIP,
+	})
+	if err != nil {
+		http.Error(rw, m.blockMessage, status)
+		return
+	}
+
+	rw.Header().Set("Content-Type", contentTypeJSON)
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	rw.WriteHeader(status)
+	_, _ = rw.Write(append(body, '\n'))
+}
+
+// errorCode turns a status into a snake_case code such as "forbidden" or
+// "too_many_requests".
+func errorCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
@@ -0,0 +1,87 @@
+package traefik_plugin
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"time"
+)
+
+const (
+	// pathSketchBits is the number of hash bits picking the register of a
+	// path sketch, which has 2^pathSketchBits registers. 64 registers keep
+	// each tracked IP's sketch at 64 bytes for an error of about 13%.
+	pathSketchBits      = 6
+	pathSketchRegisters = 1 << pathSketchBits
+)
+
+// pathSketch estimates the number of distinct paths added to it, as a
+// HyperLogLog, in constant memory however many paths a scanner tries.
+type pathSketch struct {
+	registers [pathSketchRegisters]uint8
+	// start is when the sketch's window began. Windows span FindTime from
+	// their first path.
+	start time.Time
+}
+
+// add adds path to the sketch.
+func (s *pathSketch) add(path string) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(path))
+	x := mix64(h.Sum64())
+
+	register := x >> (64 - pathSketchBits)
+	// The rank is the position of the first set bit of the remaining bits;
+	// the sentinel bit caps it when they are all zero.
+	rank := uint8(bits.LeadingZeros64(x<<pathSketchBits|1<<(pathSketchBits-1)) + 1)
+	if rank > s.registers[register] {
+		s.registers[register] = rank
+	}
+}
+
+// estimate returns the estimated number of distinct paths added.
+func (s *pathSketch) estimate() int {
+	const m = float64(pathSketchRegisters)
+
+	sum := 0.0
+	zeros := 0
+	for _, rank := range s.registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+
+	estimate := 0.709 * m * m / sum
+	// Small counts are estimated from the empty registers instead, which is
+	// far more accurate for them.
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+
+	return int(math.Round(estimate))
+}
+
+// mix64 scrambles the bits of an FNV hash, whose high bits vary little
+// between similar short strings.
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// addDistinctPath adds reqPath to the path sketch of window, restarting the
+// sketch once its window of findTime has passed, and returns the estimated
+// number of distinct paths within the window. The caller must hold m.mu for
+// writing.
+func (window *failureWindow) addDistinctPath(reqPath string, now time.Time, findTime time.Duration) int {
+	if window.paths == nil || now.Sub(window.paths.start) >= findTime {
+		window.paths = &pathSketch{start: now}
+	}
+	window.paths.add(reqPath)
+
+	return window.paths.estimate()
+}
@@ -0,0 +1,63 @@
+package traefik_plugin
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Verdicts of SelfTestCase.Expect.
+const (
+	verdictBlock = "block"
+	verdictAllow = "allow"
+)
+
+// SelfTestCase is a sample client IP and the verdict the ruleset must reach
+// for it.
+type SelfTestCase struct {
+	IP string `json:"ip"`
+	// Expect is "block" or "allow".
+	Expect string `json:"expect"`
+}
+
+// validateSelfTest checks the SelfTest configuration.
+func validateSelfTest(cases []SelfTestCase) error {
+	for i, c := range cases {
+		if net.ParseIP(strings.TrimSpace(c.IP)) == nil {
+			return fmt.Errorf("selfTest[%d]: invalid ip %q", i, c.IP)
+		}
+		if c.Expect != verdictBlock && c.Expect != verdictAllow {
+			return fmt.Errorf("selfTest[%d]: expect must be %q or %q", i, verdictBlock, verdictAllow)
+		}
+	}
+
+	return nil
+}
+
+// selfTest runs each case through the decision pipeline, as GET /check does,
+// and logs the cases whose verdict differs from the expected one. It returns
+// an error if any does.
+func (m *Fail2BanMiddleware) selfTest(cases []SelfTestCase) error {
+	now := m.nowFunc()
+	failed := 0
+	for _, c := range cases {
+		ip := normalizeIP(net.ParseIP(strings.TrimSpace(c.IP)).String())
+		result := m.check(ip, now)
+
+		verdict := verdictAllow
+		if result.Blocked {
+			verdict = verdictBlock
+		}
+		if verdict != c.Expect {
+			failed++
+			m.logger.Error("Self-test case failed", "ip", ip, "expected", c.Expect, "got", verdict, "rule", result.Rule, "reason", result.Reason)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d cases failed", failed, len(cases))
+	}
+
+	m.logger.Info("Self-test passed", "cases", len(cases))
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package traefik_plugin
+
+import (
+	"net/http"
+	"time"
+)
+
+// Directions of a divergence between the blocklists and the shadow blocklist.
+const (
+	shadowOnly  = "shadow_only"  // the shadow blocklist would block, the blocklists don't
+	primaryOnly = "primary_only" // the blocklists block, the shadow blocklist wouldn't
+)
+
+// shadowMatchers returns the matcher chain with the blocklist's links reading
+// the shadow blocklist instead.
+func shadowMatchers(matchers []ruleMatcher) []ruleMatcher {
+	shadow := make([]ruleMatcher, 0, len(matchers))
+	for _, matcher := range matchers {
+		switch matcher := matcher.(type) {
+		case exactMatcher:
+			shadow = append(shadow, exactMatcher{m: matcher.m, shadow: true})
+		case cidrMatcher:
+			shadow = append(shadow, cidrMatcher{m: matcher.m, shadow: true})
+		default:
+			shadow = append(shadow, matcher)
+		}
+	}
+
+	return shadow
+}
+
+// matchedBlocklist returns the loaded shadow blocklist if shadow is set, and
+// the loaded blocklist otherwise.
+func (m *Fail2BanMiddleware) matchedBlocklist(shadow bool) *ipList {
+	if shadow {
+		return m.shadowBlocklist.Load().(*ipList)
+	}
+	return m.currentBlocklist()
+}
+
+// loadShadowBlocklist reloads the shadow blocklist. A failed load keeps the
+// previous list, and is only logged since the shadow blocklist never decides
+// a request. The caller must hold m.reloadMu.
+func (m *Fail2BanMiddleware) loadShadowBlocklist() {
+	list, err := m.loadSource(m.shadowBlocklistPath, &m.shadowSource)
+	if err != nil {
+		m.logger.Warn("Failed to load shadow blocklist", "path", m.shadowBlocklistPath, "error", err)
+		return
+	}
+	if list == nil {
+		// The remote list hasn't changed since the last fetch.
+		return
+	}
+
+	list.index()
+	m.shadowBlocklist.Store(list)
+	// Purged so cached clients are compared against the new list.
+	m.cache.purge()
+	m.logger.Debug("Shadow blocklist loaded", "size", len(list.ips)+len(list.nets))
+}
+
+// compareShadow decides the request from clientIP again with the shadow
+// blocklist in place of the blocklists, and logs and counts it if the outcome
+// differs from d. The request is still handled as d says.
+func (m *Fail2BanMiddleware) compareShadow(req *http.Request, clientIP string, d decision, now time.Time) {
+	shadow, _ := m.decide(clientIP, req, now, m.shadowMatchers)
+	if shadow.blocked == d.blocked {
+		return
+	}
+
+	direction := primaryOnly
+	b := d.ban
+	if shadow.blocked {
+		direction = shadowOnly
+		b = shadow.ban
+	}
+	m.metrics.shadowDivergence(direction)
+	m.logger.Info("Shadow blocklist diverged", "ip", m.logIP(clientIP), "direction", direction, "rule", b.rule, "reason", b.reason, "path", req.URL.Path)
+}
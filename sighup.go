@@ -0,0 +1,50 @@
+//go:build sighup
+// +build sighup
+
+package traefik_plugin
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// reloadSignalDebounce is how long further SIGHUPs are ignored after one has
+// triggered a reload.
+const reloadSignalDebounce = time.Second
+
+// startReloadSignal makes SIGHUP reload the lists immediately, for example
+// after dropping a large list in place during an incident. It complements the
+// file watcher and periodic reloads rather than replacing them. The handler is
+// registered before startReloadSignal returns and unregistered once m.ctx is
+// cancelled.
+//
+// SIGHUP handling needs the syscall package, which Traefik's Yaegi interpreter
+// cannot load, so it is only compiled in with the "sighup" build tag; plugins
+// loaded by Traefik ignore the signal.
+func (m *Fail2BanMiddleware) startReloadSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer signal.Stop(signals)
+
+		var last time.Time
+		for {
+			select {
+			case <-m.ctx.Done():
+				return
+			case <-signals:
+				if time.Since(last) < reloadSignalDebounce {
+					continue
+				}
+				last = time.Now()
+				m.logger.Info("Received SIGHUP, reloading lists")
+				m.reloadLists()
+			}
+		}
+	}()
+}
@@ -0,0 +1,8 @@
+//go:build !sighup
+// +build !sighup
+
+package traefik_plugin
+
+// startReloadSignal does nothing; build with the "sighup" tag to reload the
+// lists on SIGHUP.
+func (m *Fail2BanMiddleware) startReloadSignal() {}
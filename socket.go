@@ -0,0 +1,159 @@
+package traefik_plugin
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// maxUpdateLineBytes caps the length of a line received on the update socket.
+const maxUpdateLineBytes = 4096
+
+// startUpdateSocket listens on the Unix socket at path for pushed ban updates.
+// A stale socket left by a previous run is removed first. It listens
+// synchronously so a bad path fails New, and stops listening and removes the
+// socket once m.ctx is cancelled.
+func (m *Fail2BanMiddleware) startUpdateSocket(path string) error {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		_ = listener.Close()
+		return err
+	}
+
+	m.wg.Add(2)
+	go func() {
+		defer m.wg.Done()
+		m.acceptUpdates(listener)
+	}()
+	go func() {
+		defer m.wg.Done()
+		<-m.ctx.Done()
+		// Closing a Unix listener also removes its socket file.
+		_ = listener.Close()
+	}()
+
+	m.logger.Info("Listening for ban updates", "socket", path)
+
+	return nil
+}
+
+// acceptUpdates serves connections to the update socket until the listener is
+// closed.
+func (m *Fail2BanMiddleware) acceptUpdates(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				m.logger.Error("Update socket stopped", "error", err)
+			}
+			return
+		}
+
+		m.wg.Add(2)
+		done := make(chan struct{})
+		go func() {
+			defer m.wg.Done()
+			defer close(done)
+			m.readUpdates(conn)
+		}()
+		go func() {
+			defer m.wg.Done()
+			select {
+			case <-m.ctx.Done():
+				_ = conn.Close()
+			case <-done:
+			}
+		}()
+	}
+}
+
+// readUpdates applies the lines received on conn until it is closed. Each
+// line holds an IP to ban, or an IP prefixed with "-" whose dynamic ban to
+// lift. Blank lines and lines starting with "#" are ignored.
+func (m *Fail2BanMiddleware) readUpdates(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, 256), maxUpdateLineBytes)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		entry := strings.TrimPrefix(line, "-")
+		remove := entry != line
+		ip := net.ParseIP(strings.TrimSpace(entry))
+		if ip == nil {
+			m.logger.Warn("Ignoring invalid ban update", "line", line)
+			continue
+		}
+
+		if remove {
+			m.liftPushedBan(normalizeIP(ip.String()))
+		} else {
+			m.applyPushedBan(normalizeIP(ip.String()))
+		}
+	}
+
+	if err := scanner.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
+		m.logger.Warn("Error reading ban updates", "error", err)
+	}
+}
+
+// applyPushedBan bans clientIP until it is lifted.
+func (m *Fail2BanMiddleware) applyPushedBan(clientIP string) {
+	b := ban{rule: rulePushed, hits: &banHits{}}
+
+	m.mu.Lock()
+	m.bans.Set(clientIP, b)
+	delete(m.unbanned, clientIP)
+	m.forgetClient(clientIP)
+	m.mu.Unlock()
+	m.cache.invalidate(clientIP)
+	m.shareBan(clientIP, b)
+
+	if m.verbose {
+		m.logger.Info("Banned IP via update socket", "ip", m.logIP(clientIP))
+	}
+}
+
+// liftPushedBan lifts the dynamic bans on clientIP and its range, whichever
+// way they were banned. Blocklist entries are unaffected.
+func (m *Fail2BanMiddleware) liftPushedBan(clientIP string) {
+	var lifted []string
+	m.mu.Lock()
+	for _, key := range m.banKeys(clientIP) {
+		if _, ok := m.bans.Get(key); ok {
+			m.bans.Delete(key)
+			lifted = append(lifted, key)
+		}
+	}
+	m.mu.Unlock()
+	if len(lifted) == 0 {
+		return
+	}
+	m.cache.purge()
+	for _, key := range lifted {
+		m.unshareBan(key)
+	}
+
+	if m.verbose {
+		m.logger.Info("Unbanned IP via update socket", "ip", m.logIP(clientIP))
+	}
+}
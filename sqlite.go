@@ -0,0 +1,349 @@
+package traefik_plugin
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// sqliteMagic starts every SQLite 3 database file.
+const sqliteMagic = "SQLite format 3\x00"
+
+// sqliteMaxDepth bounds the depth of the b-trees scanned, so a corrupt file
+// whose pages point back at each other can't recurse forever.
+const sqliteMaxDepth = 64
+
+// errSQLiteCorrupt is returned for database files that don't follow the file
+// format.
+var errSQLiteCorrupt = errors.New("malformed SQLite database")
+
+// sqliteDB is a read-only view of an SQLite 3 database file held in memory.
+// It only scans rowid tables, which is all the fail2ban import needs: the
+// plugin can't link an SQLite driver, since Yaegi can't load cgo or unsafe
+// code.
+type sqliteDB struct {
+	data     []byte
+	pageSize int
+	usable   int // page size minus the reserved bytes at the end of each page
+}
+
+// openSQLite returns a view of the database file data.
+func openSQLite(data []byte) (*sqliteDB, error) {
+	if len(data) < 100 || string(data[:len(sqliteMagic)]) != sqliteMagic {
+		return nil, errors.New("not an SQLite 3 database")
+	}
+
+	pageSize := int(binary.BigEndian.Uint16(data[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 {
+		return nil, fmt.Errorf("invalid SQLite page size %d", pageSize)
+	}
+	usable := pageSize - int(data[20])
+	if usable < 480 {
+		return nil, errSQLiteCorrupt
+	}
+
+	return &sqliteDB{data: data, pageSize: pageSize, usable: usable}, nil
+}
+
+// page returns page n, counted from 1.
+func (db *sqliteDB) page(n int) ([]byte, error) {
+	start := (n - 1) * db.pageSize
+	if n < 1 || start+db.pageSize > len(db.data) {
+		return nil, fmt.Errorf("%w: page %d out of range", errSQLiteCorrupt, n)
+	}
+
+	return db.data[start : start+db.pageSize], nil
+}
+
+// table returns the root page and column names of the named table, or a zero
+// root page if there is no such table.
+func (db *sqliteDB) table(name string) (int, []string, error) {
+	root := 0
+	var columns []string
+	// The schema table has the columns type, name, tbl_name, rootpage and
+	// sql, and is rooted at page 1.
+	err := db.scan(1, func(values []interface{}) error {
+		if len(values) < 5 {
+			return nil
+		}
+		kind, _ := values[0].(string)
+		tableName, _ := values[1].(string)
+		if kind != "table" || !strings.EqualFold(tableName, name) {
+			return nil
+		}
+		page, _ := values[3].(int64)
+		sql, _ := values[4].(string)
+		root = int(page)
+		columns = sqliteColumns(sql)
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return root, columns, nil
+}
+
+// scan calls fn with the values of each row of the table b-tree rooted at
+// page root, in rowid order. Rows written before a column was added have
+// fewer values than the table has columns.
+func (db *sqliteDB) scan(root int, fn func(values []interface{}) error) error {
+	return db.scanPage(root, 0, fn)
+}
+
+func (db *sqliteDB) scanPage(n, depth int, fn func(values []interface{}) error) error {
+	if depth > sqliteMaxDepth {
+		return fmt.Errorf("%w: b-tree too deep", errSQLiteCorrupt)
+	}
+	page, err := db.page(n)
+	if err != nil {
+		return err
+	}
+
+	// Page 1 starts with the file header.
+	header := 0
+	if n == 1 {
+		header = 100
+	}
+	if header+12 > len(page) {
+		return errSQLiteCorrupt
+	}
+	cells := int(binary.BigEndian.Uint16(page[header+3:]))
+
+	switch page[header] {
+	case 0x05: // interior table page
+		pointers := header + 12
+		if pointers+2*cells > len(page) {
+			return errSQLiteCorrupt
+		}
+		for i := 0; i < cells; i++ {
+			offset := int(binary.BigEndian.Uint16(page[pointers+2*i:]))
+			if offset+4 > len(page) {
+				return errSQLiteCorrupt
+			}
+			if err := db.scanPage(int(binary.BigEndian.Uint32(page[offset:])), depth+1, fn); err != nil {
+				return err
+			}
+		}
+		return db.scanPage(int(binary.BigEndian.Uint32(page[header+8:])), depth+1, fn)
+
+	case 0x0d: // leaf table page
+		pointers := header + 8
+		if pointers+2*cells > len(page) {
+			return errSQLiteCorrupt
+		}
+		for i := 0; i < cells; i++ {
+			offset := int(binary.BigEndian.Uint16(page[pointers+2*i:]))
+			if offset >= len(page) {
+				return errSQLiteCorrupt
+			}
+			size, k := sqliteVarint(page[offset:])
+			if k == 0 {
+				return errSQLiteCorrupt
+			}
+			offset += k
+			if offset >= len(page) {
+				return errSQLiteCorrupt
+			}
+			// Skip the rowid.
+			if _, k = sqliteVarint(page[offset:]); k == 0 {
+				return errSQLiteCorrupt
+			}
+			offset += k
+
+			payload, err := db.payload(page, offset, size)
+			if err != nil {
+				return err
+			}
+			values, err := sqliteRecord(payload)
+			if err != nil {
+				return err
+			}
+			if err := fn(values); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("%w: page %d is not a table page", errSQLiteCorrupt, n)
+}
+
+// payload returns the size bytes of the cell payload starting at offset of
+// page, following its overflow pages if it spills onto them.
+func (db *sqliteDB) payload(page []byte, offset int, size uint64) ([]byte, error) {
+	if size > uint64(len(db.data)) {
+		return nil, errSQLiteCorrupt
+	}
+	total := int(size)
+
+	maxLocal := db.usable - 35
+	if total <= maxLocal {
+		if offset+total > len(page) {
+			return nil, errSQLiteCorrupt
+		}
+		return page[offset : offset+total], nil
+	}
+
+	minLocal := (db.usable-12)*32/255 - 23
+	local := minLocal + (total-minLocal)%(db.usable-4)
+	if local > maxLocal {
+		local = minLocal
+	}
+	if offset+local+4 > len(page) {
+		return nil, errSQLiteCorrupt
+	}
+
+	payload := make([]byte, 0, total)
+	payload = append(payload, page[offset:offset+local]...)
+	next := int(binary.BigEndian.Uint32(page[offset+local:]))
+	for len(payload) < total {
+		overflow, err := db.page(next)
+		if err != nil {
+			return nil, err
+		}
+		chunk := overflow[4:db.usable]
+		if rest := total - len(payload); rest < len(chunk) {
+			chunk = chunk[:rest]
+		}
+		payload = append(payload, chunk...)
+		next = int(binary.BigEndian.Uint32(overflow))
+	}
+
+	return payload, nil
+}
+
+// sqliteRecord decodes the column values of a record: nil, int64, float64,
+// string or []byte.
+func sqliteRecord(payload []byte) ([]interface{}, error) {
+	headerSize, k := sqliteVarint(payload)
+	if k == 0 || headerSize > uint64(len(payload)) || int(headerSize) < k {
+		return nil, errSQLiteCorrupt
+	}
+
+	var types []uint64
+	header := payload[k:headerSize]
+	for len(header) > 0 {
+		t, k := sqliteVarint(header)
+		if k == 0 {
+			return nil, errSQLiteCorrupt
+		}
+		types = append(types, t)
+		header = header[k:]
+	}
+
+	body := payload[headerSize:]
+	values := make([]interface{}, 0, len(types))
+	for _, t := range types {
+		size := 0
+		switch {
+		case t >= 1 && t <= 6:
+			size = []int{0, 1, 2, 3, 4, 6, 8}[t]
+		case t == 7:
+			size = 8
+		case t >= 12:
+			size = int((t - 12) / 2)
+		case t == 10 || t == 11:
+			return nil, fmt.Errorf("%w: reserved serial type %d", errSQLiteCorrupt, t)
+		}
+		if size > len(body) {
+			return nil, errSQLiteCorrupt
+		}
+		field := body[:size]
+		body = body[size:]
+
+		switch {
+		case t == 0:
+			values = append(values, nil)
+		case t <= 6:
+			var v int64
+			for _, b := range field {
+				v = v<<8 | int64(b)
+			}
+			// Sign-extend from the field's width.
+			shift := uint(64 - 8*size)
+			values = append(values, v<<shift>>shift)
+		case t == 7:
+			values = append(values, math.Float64frombits(binary.BigEndian.Uint64(field)))
+		case t == 8:
+			values = append(values, int64(0))
+		case t == 9:
+			values = append(values, int64(1))
+		case t%2 == 0:
+			values = append(values, append([]byte(nil), field...))
+		default:
+			values = append(values, string(field))
+		}
+	}
+
+	return values, nil
+}
+
+// sqliteVarint decodes the big-endian variable-length integer at the start of
+// b and returns it with its length, or a zero length if b is too short.
+func sqliteVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9; i++ {
+		if i >= len(b) {
+			return 0, 0
+		}
+		if i == 8 {
+			return v<<8 | uint64(b[i]), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+
+	return v, 9
+}
+
+// sqliteColumns returns the lowercased column names declared by a CREATE
+// TABLE statement, in order.
+func sqliteColumns(sql string) []string {
+	start := strings.Index(sql, "(")
+	end := strings.LastIndex(sql, ")")
+	if start < 0 || end < start {
+		return nil
+	}
+
+	// Split the definitions on the commas outside parentheses.
+	var defs []string
+	depth := 0
+	from := start + 1
+	for i := start + 1; i < end; i++ {
+		switch sql[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				defs = append(defs, sql[from:i])
+				from = i + 1
+			}
+		}
+	}
+	defs = append(defs, sql[from:end])
+
+	var columns []string
+	for _, def := range defs {
+		fields := strings.Fields(def)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN":
+			continue
+		}
+		columns = append(columns, strings.ToLower(strings.Trim(fields[0], "\"`[]'")))
+	}
+
+	return columns
+}
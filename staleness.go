@@ -0,0 +1,78 @@
+package traefik_plugin
+
+import (
+	"os"
+	"time"
+)
+
+// staleCheckInterval is how often the age of the blocklist files is checked
+// against MaxBlocklistAge, and so how often a stale blocklist is logged.
+const staleCheckInterval = 5 * time.Minute
+
+// recordBlocklistAge records the modification time of the least recently
+// modified blocklist file among paths. URLs and environment variables have
+// no age and are skipped, as are files that can't be read. The caller must
+// hold m.reloadMu.
+func (m *Fail2BanMiddleware) recordBlocklistAge(paths []string) {
+	var oldest string
+	var modTime time.Time
+	for _, path := range paths {
+		if !isFile(path) {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if oldest == "" || info.ModTime().Before(modTime) {
+			oldest = path
+			modTime = info.ModTime()
+		}
+	}
+
+	m.mu.Lock()
+	m.oldestBlocklistFile = oldest
+	m.oldestBlocklistModTime = modTime
+	m.mu.Unlock()
+}
+
+// blocklistAge returns the least recently modified blocklist file, how long
+// ago at now it was modified, and whether it is older than MaxBlocklistAge.
+// path is empty when no blocklist file has been loaded.
+func (m *Fail2BanMiddleware) blocklistAge(now time.Time) (path string, age time.Duration, stale bool) {
+	m.mu.RLock()
+	path = m.oldestBlocklistFile
+	modTime := m.oldestBlocklistModTime
+	m.mu.RUnlock()
+
+	if path == "" {
+		return "", 0, false
+	}
+	age = now.Sub(modTime)
+
+	return path, age, m.maxBlocklistAge > 0 && age > m.maxBlocklistAge
+}
+
+// watchBlocklistAge checks the age of the blocklist files every
+// staleCheckInterval, updating the staleness metric and warning while a file
+// is older than MaxBlocklistAge. It returns once m.ctx is cancelled.
+func (m *Fail2BanMiddleware) watchBlocklistAge() {
+	ticker := time.NewTicker(staleCheckInterval)
+	defer ticker.Stop()
+
+	now := m.nowFunc()
+	for {
+		path, age, stale := m.blocklistAge(now)
+		m.metrics.setBlocklistStale(stale)
+		if stale {
+			m.logger.Warn("Blocklist file is stale", "path", path, "age", age.Round(time.Second), "maxAge", m.maxBlocklistAge)
+		}
+
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+			now = m.nowFunc()
+		}
+	}
+}
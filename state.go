@@ -0,0 +1,118 @@
+package traefik_plugin
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stateFlushInterval is how often dynamic bans are written to the state file.
+const stateFlushInterval = time.Minute
+
+// state is the content of the state file.
+type state struct {
+	Bans   []banEntry   `json:"bans"`
+	Allows []allowEntry `json:"allows,omitempty"`
+}
+
+// loadState merges the unexpired bans and temporary allows from the state
+// file into m.bans and m.tempAllows. A missing state file is not an error.
+func (m *Fail2BanMiddleware) loadState() error {
+	data, err := os.ReadFile(m.statePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var s state
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	now := m.nowFunc()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, entry := range s.Bans {
+		b := entry.toBan()
+		// The file may have been edited by hand, so its IPs are
+		// normalized to match the client IPs.
+		if b.expiry.IsZero() || now.Before(b.expiry) {
+			m.bans.Set(normalizeIP(entry.IP), b)
+		}
+	}
+	for _, entry := range s.Allows {
+		if now.Before(entry.ExpiresAt) {
+			m.tempAllows[normalizeIP(entry.IP)] = tempAllow{expiry: entry.ExpiresAt, note: entry.Note}
+		}
+	}
+
+	return nil
+}
+
+// saveState writes the unexpired dynamic bans and temporary allows to the
+// state file. The file is written to a temporary file first and renamed into
+// place, so a crash never leaves a truncated state file behind.
+func (m *Fail2BanMiddleware) saveState() error {
+	now := m.nowFunc()
+	s := state{Bans: []banEntry{}}
+
+	m.mu.RLock()
+	for ip, b := range m.bans.List() {
+		if b.expiry.IsZero() || now.Before(b.expiry) {
+			s.Bans = append(s.Bans, newBanEntry(ip, b))
+		}
+	}
+	m.mu.RUnlock()
+	s.Allows = m.allowEntries(now)
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(m.statePath), filepath.Base(m.statePath)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), m.statePath)
+}
+
+// persistState saves the state file every stateFlushInterval, and a final
+// time once m.ctx is cancelled.
+func (m *Fail2BanMiddleware) persistState() {
+	ticker := time.NewTicker(stateFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			if err := m.saveState(); err != nil {
+				m.logger.Error("Error saving state", "error", err)
+			}
+			return
+		case <-ticker.C:
+			if err := m.saveState(); err != nil {
+				m.logger.Error("Error saving state", "error", err)
+			}
+		}
+	}
+}
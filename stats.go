@@ -0,0 +1,96 @@
+package traefik_plugin
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a dependency-free summary of what the middleware has done, for
+// deployments without Prometheus.
+type Stats struct {
+	RequestsTotal uint64 `json:"requestsTotal"`
+	BlockedTotal  uint64 `json:"blockedTotal"`
+	// BlockedByCategory breaks BlockedTotal down by the rule that blocked
+	// the request, such as "exact", "cidr", "rate", "geo" or "rate_limit".
+	BlockedByCategory map[string]uint64 `json:"blockedByCategory"`
+	BlocklistSize     int               `json:"blocklistSize"`
+	DynamicBans       int               `json:"dynamicBans"`
+	UptimeSeconds     int64             `json:"uptimeSeconds"`
+	// Lockdown tells whether clients not on the allowlist are rejected.
+	Lockdown bool `json:"lockdown"`
+	// Paused tells whether enforcement is paused through the admin API, and
+	// PausedRemainingSeconds for how much longer.
+	Paused                 bool  `json:"paused"`
+	PausedRemainingSeconds int64 `json:"pausedRemainingSeconds,omitempty"`
+	// BlocklistAgeSeconds is how long ago the least recently modified
+	// blocklist file was modified, and Stale whether that exceeds
+	// MaxBlocklistAge.
+	BlocklistAgeSeconds int64 `json:"blocklistAgeSeconds,omitempty"`
+	Stale               bool  `json:"stale"`
+	// Probation is set with ProbationAfter.
+	Probation *ProbationStats `json:"probation,omitempty"`
+}
+
+// Stats returns the request counters since New along with the current number
+// of blocklist entries and unexpired dynamic bans.
+func (m *Fail2BanMiddleware) Stats() Stats {
+	now := m.nowFunc()
+	list := m.currentBlocklist()
+
+	s := Stats{
+		RequestsTotal: atomic.LoadUint64(&m.requestsTotal),
+		BlockedTotal:  atomic.LoadUint64(&m.blockedTotal),
+		BlocklistSize: len(list.ips) + len(list.nets),
+		UptimeSeconds: int64(now.Sub(m.started) / time.Second),
+		Lockdown:      m.inLockdown(),
+	}
+
+	pause := m.currentPauseState(now)
+	s.Paused = pause.Paused
+	s.PausedRemainingSeconds = pause.RemainingSeconds
+
+	_, age, stale := m.blocklistAge(now)
+	s.BlocklistAgeSeconds = int64(age / time.Second)
+	s.Stale = stale
+
+	s.BlockedByCategory = make(map[string]uint64, len(m.blockedByCategory))
+	for category, counter := range m.blockedByCategory {
+		s.BlockedByCategory[category] = atomic.LoadUint64(counter)
+	}
+
+	if m.probationAfter > 0 {
+		probation := m.probationStats(now)
+		s.Probation = &probation
+	}
+
+	m.mu.RLock()
+	for _, b := range m.bans.List() {
+		if b.expiry.IsZero() || now.Before(b.expiry) {
+			s.DynamicBans++
+		}
+	}
+	m.mu.RUnlock()
+
+	return s
+}
+
+// countBlocked counts a blocked request under category, the rule that blocked
+// it, in the metrics, with its trace ID, and the Stats counters.
+func (m *Fail2BanMiddleware) countBlocked(category, traceID string) {
+	m.metrics.requestBlocked(category, traceID)
+	atomic.AddUint64(&m.blockedTotal, 1)
+	if counter, ok := m.blockedByCategory[category]; ok {
+		atomic.AddUint64(counter, 1)
+	}
+}
+
+// handleStats serves Stats.
+func (m *Fail2BanMiddleware) handleStats(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		writeMethodNotAllowed(rw, http.MethodGet)
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, m.Stats())
+}
@@ -0,0 +1,269 @@
+package traefik_plugin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Networks available to Config.SyslogNetwork.
+const (
+	syslogUDP = "udp"
+	syslogTCP = "tcp"
+)
+
+const (
+	// syslogQueueSize bounds the messages waiting to be sent. Messages beyond
+	// it are dropped, so a slow syslog server can't hold up requests.
+	syslogQueueSize = 1000
+
+	// syslogTimeout bounds connecting to the syslog server and each write.
+	syslogTimeout = 5 * time.Second
+
+	// syslogRetryDelay is how long messages are dropped after the syslog
+	// server couldn't be reached, before connecting is tried again.
+	syslogRetryDelay = 10 * time.Second
+
+	// defaultSyslogFacility is the facility messages are sent with when
+	// SyslogFacility is empty.
+	defaultSyslogFacility = "local0"
+
+	// syslogAppName is the APP-NAME of the messages.
+	syslogAppName = "traefik-fail2ban"
+
+	// syslogSDID names the structured data element carrying an event's
+	// fields. No enterprise number is registered for the plugin, so it uses
+	// the one RFC 5612 reserves for documentation.
+	syslogSDID = "fail2ban@32473"
+)
+
+// Severities of the messages: bans are warnings, blocked requests notices.
+const (
+	syslogWarning = 4
+	syslogNotice  = 5
+)
+
+// syslogFacilities are the facilities available to Config.SyslogFacility, by
+// name.
+var syslogFacilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// validateSyslog checks the syslog settings.
+func validateSyslog(c *Config) error {
+	if c.SyslogAddr == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(c.SyslogAddr); err != nil {
+		return fmt.Errorf("invalid syslogAddr: %w", err)
+	}
+	switch c.SyslogNetwork {
+	case "", syslogUDP, syslogTCP:
+	default:
+		return fmt.Errorf("syslogNetwork must be %q or %q", syslogUDP, syslogTCP)
+	}
+	if _, ok := syslogFacilities[c.SyslogFacility]; c.SyslogFacility != "" && !ok {
+		return fmt.Errorf("unknown syslogFacility %q", c.SyslogFacility)
+	}
+
+	return nil
+}
+
+// syslogWriter sends ban and block events to a syslog server in RFC 5424
+// format, over UDP one message per datagram or over TCP with octet-counting
+// framing (RFC 6587). Events are queued and sent by run, so a syslog server
+// that is down or slow only ever costs dropped messages.
+type syslogWriter struct {
+	network  string
+	addr     string
+	facility int
+	hostname string
+	procID   string
+	now      func() time.Time
+	logger   *slog.Logger
+
+	queue chan banEvent
+	done  chan struct{} // closed when run returns
+
+	// Owned by run.
+	conn    net.Conn // nil while disconnected
+	retryAt time.Time
+}
+
+// newSyslogWriter returns a writer for the validated SyslogNetwork, SyslogAddr
+// and SyslogFacility, timing its reconnection attempts with now.
+func newSyslogWriter(network, addr, facility string, now func() time.Time, logger *slog.Logger) *syslogWriter {
+	if network == "" {
+		network = syslogUDP
+	}
+	if facility == "" {
+		facility = defaultSyslogFacility
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	return &syslogWriter{
+		network:  network,
+		addr:     addr,
+		facility: syslogFacilities[facility],
+		hostname: hostname,
+		procID:   strconv.Itoa(os.Getpid()),
+		now:      now,
+		logger:   logger,
+		queue:    make(chan banEvent, syslogQueueSize),
+		done:     make(chan struct{}),
+	}
+}
+
+// send queues event. It never blocks: when the queue is full the event is
+// dropped.
+func (w *syslogWriter) send(event banEvent) {
+	select {
+	case w.queue <- event:
+	default:
+		w.logger.Debug("Dropping syslog message, queue is full", "event", event.Event, "ip", event.IP)
+	}
+}
+
+// run sends queued events until ctx is cancelled, or until the queue is empty
+// once drained is closed.
+func (w *syslogWriter) run(ctx context.Context, drained <-chan struct{}) {
+	defer close(w.done)
+	defer w.disconnect()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-drained:
+			for {
+				select {
+				case event := <-w.queue:
+					w.write(event)
+				default:
+					return
+				}
+			}
+		case event := <-w.queue:
+			w.write(event)
+		}
+	}
+}
+
+// write sends event, connecting first if needed. While the server can't be
+// reached events are dropped, and connecting is only tried again after
+// syslogRetryDelay.
+func (w *syslogWriter) write(event banEvent) {
+	now := w.now()
+	if w.conn == nil {
+		if now.Before(w.retryAt) {
+			return
+		}
+		conn, err := net.DialTimeout(w.network, w.addr, syslogTimeout)
+		if err != nil {
+			w.retryAt = now.Add(syslogRetryDelay)
+			w.logger.Warn("Failed to connect to syslog server, dropping messages", "addr", w.addr, "retryIn", syslogRetryDelay.String(), "error", err)
+			return
+		}
+		w.conn = conn
+	}
+
+	msg := w.format(event)
+	if w.network == syslogTCP {
+		msg = strconv.Itoa(len(msg)) + " " + msg
+	}
+	_ = w.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	if _, err := io.WriteString(w.conn, msg); err != nil {
+		w.disconnect()
+		w.retryAt = now.Add(syslogRetryDelay)
+		w.logger.Warn("Failed to write to syslog server, dropping messages", "addr", w.addr, "retryIn", syslogRetryDelay.String(), "error", err)
+	}
+}
+
+// disconnect closes the connection to the syslog server, if any.
+func (w *syslogWriter) disconnect() {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+}
+
+// format returns event as an RFC 5424 message, with its fields as structured
+// data and the event as MSGID.
+func (w *syslogWriter) format(event banEvent) string {
+	severity := syslogNotice
+	verb := "Blocked"
+	if event.Event == eventBan {
+		severity = syslogWarning
+		verb = "Banned"
+	}
+
+	var sd strings.Builder
+	sd.WriteString("[" + syslogSDID)
+	param := func(name, value string) {
+		if value != "" {
+			sd.WriteString(" " + name + `="` + syslogEscape(value) + `"`)
+		}
+	}
+	param("middleware", event.Middleware)
+	param("ip", event.IP)
+	param("rule", event.Rule)
+	param("reason", event.Reason)
+	param("path", event.Path)
+	param("duration", event.Duration)
+	if event.ExpiresAt != nil {
+		param("expiresAt", event.ExpiresAt.Format(time.RFC3339))
+	}
+	param("note", event.Note)
+	param("traceId", event.TraceID)
+	sd.WriteString("]")
+
+	return fmt.Sprintf("<%d>1 %s %s %s %s %s %s %s %s by %s",
+		w.facility*8+severity,
+		event.Time.Format("2006-01-02T15:04:05.000000Z07:00"),
+		w.hostname, syslogAppName, w.procID, event.Event,
+		sd.String(), verb, event.IP, event.Rule)
+}
+
+// syslogEscape escapes the characters RFC 5424 reserves in structured data
+// values.
+func syslogEscape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "]", `\]`).Replace(value)
+}
+
+// sendSyslog sends event to the syslog server, if one is configured, with its
+// IP anonymized under AnonymizeLoggedIPs.
+func (m *Fail2BanMiddleware) sendSyslog(event banEvent) {
+	if m.syslog == nil {
+		return
+	}
+	event.IP = m.logIP(event.IP)
+	m.syslog.send(event)
+}
@@ -0,0 +1,41 @@
+package traefik_plugin
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestSyslogRetryWithFakeClock checks that a syslog server that can't be
+// reached is only tried again after syslogRetryDelay on the writer's clock.
+func TestSyslogRetryWithFakeClock(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close() // nothing listens, so connecting fails
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	w := newSyslogWriter(syslogTCP, addr, "", func() time.Time { return now }, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	event := banEvent{Event: eventBan, IP: "192.0.2.1", Rule: ruleRate, Time: now}
+
+	w.write(event)
+	if want := now.Add(syslogRetryDelay); !w.retryAt.Equal(want) {
+		t.Fatalf("retryAt = %s, want %s", w.retryAt, want)
+	}
+
+	now = now.Add(syslogRetryDelay - time.Second)
+	w.write(event)
+	if want := now.Add(time.Second); !w.retryAt.Equal(want) {
+		t.Errorf("write within syslogRetryDelay reconnected: retryAt = %s, want %s", w.retryAt, want)
+	}
+
+	now = now.Add(2 * time.Second)
+	w.write(event)
+	if want := now.Add(syslogRetryDelay); !w.retryAt.Equal(want) {
+		t.Errorf("write after syslogRetryDelay didn't reconnect: retryAt = %s, want %s", w.retryAt, want)
+	}
+}
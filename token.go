@@ -0,0 +1,103 @@
+package traefik_plugin
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxUsedBypassTokens caps how many used bypass tokens are remembered until
+// they expire. Beyond it, further tokens are refused until some expire.
+const maxUsedBypassTokens = 10000
+
+// bypassTokens checks the signed tokens of BypassTokenParam and remembers
+// those already used, by signature, until they expire.
+type bypassTokens struct {
+	param  string
+	secret []byte
+
+	mu   sync.Mutex
+	used map[string]time.Time
+}
+
+// signBypassToken returns the signature of the token payload, the expiry and
+// nonce joined by a dot, under secret.
+func signBypassToken(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// use reports whether token is valid at now and wasn't used before, and
+// marks it used. A token is "<expiry>.<nonce>.<signature>", with the expiry
+// in Unix seconds, any nonce telling tokens of the same expiry apart, and
+// the unpadded base64url HMAC-SHA256 of "<expiry>.<nonce>" as signature.
+func (t *bypassTokens) use(token string, now time.Time) (bool, string) {
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 {
+		return false, "malformed"
+	}
+	payload := token[:i]
+	signature := token[i+1:]
+	if !hmac.Equal([]byte(signature), []byte(signBypassToken(t.secret, payload))) {
+		return false, "bad signature"
+	}
+
+	j := strings.IndexByte(payload, '.')
+	if j < 0 {
+		return false, "malformed"
+	}
+	unix, err := strconv.ParseInt(payload[:j], 10, 64)
+	if err != nil {
+		return false, "malformed"
+	}
+	expiry := time.Unix(unix, 0)
+	if !now.Before(expiry) {
+		return false, "expired"
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.used[signature]; ok {
+		return false, "replayed"
+	}
+	if len(t.used) >= maxUsedBypassTokens {
+		for used, usedExpiry := range t.used {
+			if !now.Before(usedExpiry) {
+				delete(t.used, used)
+			}
+		}
+		if len(t.used) >= maxUsedBypassTokens {
+			return false, "too many tokens in use"
+		}
+	}
+	t.used[signature] = expiry
+
+	return true, ""
+}
+
+// bypassedByToken reports whether req carries a valid, unused bypass token in
+// BypassTokenParam. Each token lets one request through.
+func (m *Fail2BanMiddleware) bypassedByToken(req *http.Request) bool {
+	if m.bypassTokens == nil {
+		return false
+	}
+
+	token := req.URL.Query().Get(m.bypassTokens.param)
+	if token == "" {
+		return false
+	}
+
+	ok, why := m.bypassTokens.use(token, m.nowFunc())
+	if !ok {
+		m.logger.Debug("Refusing bypass token", "reason", why, "remoteAddr", req.RemoteAddr, "path", req.URL.Path)
+	}
+
+	return ok
+}
@@ -0,0 +1,29 @@
+package traefik_plugin
+
+import (
+	"net/http"
+	"strings"
+)
+
+// maxTraceIDLength caps the trace IDs attached to metrics and events; longer
+// header values are ignored rather than cut, which would break the link.
+const maxTraceIDLength = 64
+
+// traceID returns the trace ID of req from TraceIDHeader, or "" if it is unset
+// or the request carries none. A W3C traceparent value yields its trace-id
+// field, other values are taken whole.
+func (m *Fail2BanMiddleware) traceID(req *http.Request) string {
+	if m.traceIDHeader == "" {
+		return ""
+	}
+
+	value := strings.TrimSpace(req.Header.Get(m.traceIDHeader))
+	if fields := strings.Split(value, "-"); len(fields) == 4 && len(fields[1]) == 32 {
+		return fields[1]
+	}
+	if len(value) > maxTraceIDLength {
+		return ""
+	}
+
+	return value
+}
@@ -0,0 +1,171 @@
+package traefik_plugin
+
+import "net"
+
+// Indexes of the root nodes in cidrTrie.nodes.
+const (
+	trieRootV4 = 0
+	trieRootV6 = 1
+)
+
+// cidrTrie is a binary trie keyed on address bits, used to find the CIDRs
+// containing an IP in O(address bits) instead of scanning every range. IPv4
+// and IPv6 ranges live in separate trees. Nodes refer to their children by
+// index into nodes rather than by pointer, because Traefik's Yaegi interpreter
+// mishandles self-referential struct types.
+type cidrTrie struct {
+	nodes []trieNode
+}
+
+// trieNode is a trie node; ipNet is set when a CIDR ends at the node. A zero
+// child index means there is no child, since the roots are never children.
+type trieNode struct {
+	children [2]int
+	ipNet    *net.IPNet
+}
+
+// newCIDRTrie returns a trie containing nets.
+func newCIDRTrie(nets []*net.IPNet) *cidrTrie {
+	t := &cidrTrie{nodes: make([]trieNode, 2)}
+	for _, ipNet := range nets {
+		t.insert(ipNet)
+	}
+
+	return t
+}
+
+// insert adds ipNet to the trie.
+func (t *cidrTrie) insert(ipNet *net.IPNet) {
+	node, key := trieRoot(ipNet.IP, len(ipNet.Mask) == net.IPv4len)
+	if key == nil {
+		return
+	}
+
+	ones, _ := ipNet.Mask.Size()
+	for i := 0; i < ones; i++ {
+		b := bitAt(key, i)
+		if t.nodes[node].children[b] == 0 {
+			t.nodes = append(t.nodes, trieNode{})
+			t.nodes[node].children[b] = len(t.nodes) - 1
+		}
+		node = t.nodes[node].children[b]
+	}
+	t.nodes[node].ipNet = ipNet
+}
+
+// lookup returns the most specific CIDR containing ip, or nil if none does.
+func (t *cidrTrie) lookup(ip net.IP) *net.IPNet {
+	node, key := trieRoot(ip, ip.To4() != nil)
+	if key == nil {
+		return nil
+	}
+
+	var match *net.IPNet
+	for i := 0; ; i++ {
+		if t.nodes[node].ipNet != nil {
+			match = t.nodes[node].ipNet
+		}
+		if i == len(key)*8 {
+			break
+		}
+		node = t.nodes[node].children[bitAt(key, i)]
+		if node == 0 {
+			break
+		}
+	}
+
+	return match
+}
+
+// lookupAll returns every CIDR containing ip, broadest first.
+func (t *cidrTrie) lookupAll(ip net.IP) []*net.IPNet {
+	node, key := trieRoot(ip, ip.To4() != nil)
+	if key == nil {
+		return nil
+	}
+
+	var matches []*net.IPNet
+	for i := 0; ; i++ {
+		if t.nodes[node].ipNet != nil {
+			matches = append(matches, t.nodes[node].ipNet)
+		}
+		if i == len(key)*8 {
+			break
+		}
+		node = t.nodes[node].children[bitAt(key, i)]
+		if node == 0 {
+			break
+		}
+	}
+
+	return matches
+}
+
+// trieRoot returns the root node index and key bytes for ip in the IPv4 or
+// IPv6 tree.
+func trieRoot(ip net.IP, v4 bool) (int, []byte) {
+	if v4 {
+		return trieRootV4, ip.To4()
+	}
+
+	return trieRootV6, ip.To16()
+}
+
+// bitAt returns bit i of key, counting from the most significant bit.
+func bitAt(key []byte, i int) int {
+	return int(key[i/8]>>(7-uint(i%8))) & 1
+}
+
+// aggregateNets returns the minimal set of CIDRs covering the same addresses
+// as nets: overlapping ranges collapse into the enclosing one and sibling
+// ranges into their parent block.
+func aggregateNets(nets []*net.IPNet) []*net.IPNet {
+	t := newCIDRTrie(nets)
+	full := make([]bool, len(t.nodes))
+	t.markFull(trieRootV4, full)
+	t.markFull(trieRootV6, full)
+
+	var out []*net.IPNet
+	out = t.cover(trieRootV4, make([]byte, net.IPv4len), 0, full, out)
+	out = t.cover(trieRootV6, make([]byte, net.IPv6len), 0, full, out)
+
+	return out
+}
+
+// markFull sets full for the nodes whose whole range is covered, either by a
+// CIDR ending there or by both children, and reports whether node is.
+func (t *cidrTrie) markFull(node int, full []bool) bool {
+	covered := true
+	for _, child := range t.nodes[node].children {
+		if child == 0 || !t.markFull(child, full) {
+			covered = false
+		}
+	}
+	full[node] = covered || t.nodes[node].ipNet != nil
+
+	return full[node]
+}
+
+// cover appends the CIDRs of the topmost full nodes below node to out. key
+// holds the address bits of node, which sits at depth.
+func (t *cidrTrie) cover(node int, key []byte, depth int, full []bool, out []*net.IPNet) []*net.IPNet {
+	if full[node] {
+		ip := make(net.IP, len(key))
+		copy(ip, key)
+		return append(out, &net.IPNet{IP: ip, Mask: net.CIDRMask(depth, len(key)*8)})
+	}
+
+	for b, child := range t.nodes[node].children {
+		if child == 0 {
+			continue
+		}
+		bit := byte(1) << (7 - uint(depth%8))
+		if b == 1 {
+			key[depth/8] |= bit
+		}
+		out = t.cover(child, key, depth+1, full, out)
+		key[depth/8] &^= bit
+	}
+
+	return out
+}
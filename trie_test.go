@@ -0,0 +1,52 @@
+package traefik_plugin
+
+import (
+	"math/rand"
+	"net"
+	"strconv"
+	"testing"
+)
+
+// benchmarkNets returns n random IPv4 ranges of /16 to /28 and probe
+// addresses, a fixed set for a given n.
+func benchmarkNets(n int) ([]*net.IPNet, []net.IP) {
+	rng := rand.New(rand.NewSource(1))
+	nets := make([]*net.IPNet, 0, n)
+	for i := 0; i < n; i++ {
+		ip := net.IPv4(byte(rng.Intn(256)), byte(rng.Intn(256)), byte(rng.Intn(256)), byte(rng.Intn(256))).To4()
+		mask := net.CIDRMask(16+rng.Intn(13), 8*net.IPv4len)
+		nets = append(nets, &net.IPNet{IP: ip.Mask(mask), Mask: mask})
+	}
+
+	probes := make([]net.IP, 0, 1024)
+	for i := 0; i < cap(probes); i++ {
+		probes = append(probes, net.IPv4(byte(rng.Intn(256)), byte(rng.Intn(256)), byte(rng.Intn(256)), byte(rng.Intn(256))))
+	}
+
+	return nets, probes
+}
+
+// BenchmarkCIDRTrie compares a trie lookup with the linear scan it replaced,
+// over blocklists of growing size.
+func BenchmarkCIDRTrie(b *testing.B) {
+	for _, size := range []int{100, 10000} {
+		nets, probes := benchmarkNets(size)
+		trie := newCIDRTrie(nets)
+		for _, ip := range probes {
+			if (trie.lookup(ip) != nil) != containsIP(nets, ip) {
+				b.Fatalf("trie and linear scan disagree on %s", ip)
+			}
+		}
+
+		b.Run("trie/"+strconv.Itoa(size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				trie.lookup(probes[i%len(probes)])
+			}
+		})
+		b.Run("linear/"+strconv.Itoa(size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				containsIP(nets, probes[i%len(probes)])
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package traefik_plugin
+
+import (
+	"math/rand"
+	"time"
+)
+
+// defaultMaxReloadBackoff caps the delay between periodic reloads after
+// repeated failures when MaxReloadBackoff is unset.
+const defaultMaxReloadBackoff = 10 * time.Minute
+
+// reloadSchedule times the periodic reloads of a watcher. While reloads succeed
+// they run every reloadInterval. After consecutive failures the delay doubles
+// with each failure, with jitter so instances sharing a source don't retry in
+// lockstep, up to maxReloadBackoff; the first success returns to the normal
+// interval. It is only used by the watcher goroutine.
+type reloadSchedule struct {
+	m        *Fail2BanMiddleware
+	timer    *time.Timer // nil when periodic reloads are disabled
+	failures int
+}
+
+// newReloadSchedule returns a schedule with the first periodic reload due in
+// reloadInterval.
+func (m *Fail2BanMiddleware) newReloadSchedule() *reloadSchedule {
+	s := &reloadSchedule{m: m}
+	if interval := m.settings().reloadInterval; interval > 0 {
+		s.timer = time.NewTimer(interval)
+	}
+
+	return s
+}
+
+// due returns the channel the next periodic reload is due on, which is nil
+// when periodic reloads are disabled.
+func (s *reloadSchedule) due() <-chan time.Time {
+	if s.timer == nil {
+		return nil
+	}
+	return s.timer.C
+}
+
+// stop stops the timer.
+func (s *reloadSchedule) stop() {
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+// reload reloads the lists and schedules the next periodic reload by the
+// outcome. periodic tells whether the reload is the one that was due. Only the
+// first failure in a row and those of periodic reloads are logged, so a source
+// that stays broken logs once per backoff cycle however often its file
+// changes.
+func (s *reloadSchedule) reload(periodic bool) {
+	blockErr, allowErr := s.m.loadLists()
+	failed := blockErr != nil || allowErr != nil
+
+	switch {
+	case failed:
+		s.failures++
+		if s.failures > 1 && !periodic {
+			// Keep waiting for the pending backoff.
+			return
+		}
+		s.m.logReloadErrors(blockErr, allowErr)
+	case s.failures > 0:
+		s.m.logger.Info("Reloaded lists after failures", "failures", s.failures)
+		s.failures = 0
+	case !periodic:
+		// The periodic reload stays where it was.
+		return
+	}
+
+	if s.timer == nil {
+		return
+	}
+
+	delay := s.delay()
+	if failed {
+		s.m.logger.Warn("Backing off list reloads", "failures", s.failures, "retryIn", delay)
+	}
+	if !s.timer.Stop() && !periodic {
+		<-s.timer.C
+	}
+	s.timer.Reset(delay)
+}
+
+// delay returns how long to wait before the next periodic reload.
+func (s *reloadSchedule) delay() time.Duration {
+	interval := s.m.settings().reloadInterval
+	if s.failures == 0 {
+		return interval
+	}
+
+	backoff := interval
+	for i := 0; i < s.failures && backoff < s.m.maxReloadBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > s.m.maxReloadBackoff {
+		backoff = s.m.maxReloadBackoff
+	}
+
+	// Wait between half and all of the backoff, but never less than the
+	// normal interval.
+	delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+	if delay < interval {
+		delay = interval
+	}
+
+	return delay
+}
+
+// reloadLists reloads the blocklist and allowlist, logging any failure. A
+// failed reload keeps the previously loaded list.
+func (m *Fail2BanMiddleware) reloadLists() {
+	blockErr, allowErr := m.loadLists()
+	m.logReloadErrors(blockErr, allowErr)
+}
+
+// loadLists reloads the blocklist and allowlist and returns their failures,
+// along with BlockBodyFile, which logs its own.
+func (m *Fail2BanMiddleware) loadLists() (blockErr, allowErr error) {
+	blockErr = m.reloadBlocklist()
+	allowErr = m.reloadAllowlist()
+	if m.blockBodyFile != "" {
+		m.loadBlockBody()
+	}
+
+	return blockErr, allowErr
+}
+
+// logReloadErrors logs the failures returned by loadLists.
+func (m *Fail2BanMiddleware) logReloadErrors(blockErr, allowErr error) {
+	if blockErr != nil {
+		m.logger.Error("Error reloading blocklist", "error", blockErr)
+	}
+	if allowErr != nil {
+		m.logger.Error("Error reloading allowlist", "error", allowErr)
+	}
+}
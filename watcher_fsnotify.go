@@ -0,0 +1,108 @@
+//go:build fsnotify
+// +build fsnotify
+
+package traefik_plugin
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDebounce is how long the watcher waits after the last file event
+// before reloading, so a burst of writes triggers a single reload.
+const reloadDebounce = 500 * time.Millisecond
+
+// watchBlocklistFile watches the directories containing the blocklists,
+// allowlist and BlockBodyFile and reloads them whenever one of the files is
+// written, created or renamed. Adding or removing a *.txt file in the
+// blocklist directory also triggers a reload. The lists are also reloaded
+// every reloadInterval, which refreshes remote and environment blocklists and
+// covers watches silently dropped by the filesystem; while reloads fail the
+// periodic ones back off. Directories are watched rather than the files
+// themselves so that files replaced through a rename are still picked up. It
+// returns once m.ctx is cancelled.
+//
+// This watcher is only compiled in with the "fsnotify" build tag, since
+// Traefik's Yaegi interpreter cannot load fsnotify.
+func (m *Fail2BanMiddleware) watchBlocklistFile() {
+	settings := m.settings()
+	watched := make(map[string]struct{})
+	for _, path := range settings.blocklistPaths {
+		if isFile(path) {
+			watched[filepath.Clean(path)] = struct{}{}
+		}
+	}
+	if settings.allowlistPath != "" {
+		watched[filepath.Clean(settings.allowlistPath)] = struct{}{}
+	}
+	if m.blockBodyFile != "" {
+		watched[filepath.Clean(m.blockBodyFile)] = struct{}{}
+	}
+
+	dirs := make(map[string]struct{})
+	for path := range watched {
+		dirs[filepath.Dir(path)] = struct{}{}
+	}
+	blocklistDir := ""
+	if m.blocklistDir != "" {
+		blocklistDir = filepath.Clean(m.blocklistDir)
+		dirs[blocklistDir] = struct{}{}
+	}
+
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		m.logger.Error("Error creating file watcher, relying on periodic reloads", "error", err)
+	} else {
+		defer watcher.Close()
+
+		events, errs = watcher.Events, watcher.Errors
+		for dir := range dirs {
+			if err := watcher.Add(dir); err != nil {
+				m.logger.Error("Error watching directory, relying on periodic reloads", "dir", dir, "error", err)
+			}
+		}
+	}
+
+	schedule := m.newReloadSchedule()
+	defer schedule.stop()
+
+	debounce := time.NewTimer(reloadDebounce)
+	debounce.Stop()
+	defer debounce.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			name := filepath.Clean(event.Name)
+			_, isWatched := watched[name]
+			inDir := blocklistDir != "" && filepath.Dir(name) == blocklistDir && filepath.Ext(name) == ".txt"
+			if !isWatched && !inDir {
+				continue
+			}
+			if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) || event.Has(fsnotify.Rename) || (inDir && event.Has(fsnotify.Remove)) {
+				debounce.Reset(reloadDebounce)
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			m.logger.Error("Error watching list files", "error", err)
+		case <-debounce.C:
+			schedule.reload(false)
+		case <-schedule.due():
+			schedule.reload(true)
+		}
+	}
+}
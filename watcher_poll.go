@@ -0,0 +1,22 @@
+//go:build !fsnotify
+// +build !fsnotify
+
+package traefik_plugin
+
+// watchBlocklistFile reloads the lists every reloadInterval, backing off while
+// reloads fail. Reacting to file changes as they happen needs fsnotify, which
+// Traefik's Yaegi interpreter cannot load, so it is only compiled in with the
+// "fsnotify" build tag. It returns once m.ctx is cancelled.
+func (m *Fail2BanMiddleware) watchBlocklistFile() {
+	schedule := m.newReloadSchedule()
+	defer schedule.stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-schedule.due():
+			schedule.reload(true)
+		}
+	}
+}
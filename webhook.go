@@ -0,0 +1,215 @@
+package traefik_plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// webhookTimeout bounds each delivery attempt of a ban event.
+	webhookTimeout = 5 * time.Second
+
+	// webhookAttempts is how often delivering an event is tried, waiting
+	// webhookRetryDelay after the first failure and twice as long after each
+	// further one.
+	webhookAttempts   = 3
+	webhookRetryDelay = time.Second
+
+	// webhookQueueSize bounds the events waiting for delivery. Events beyond
+	// it are dropped, so a slow webhook can't hold up banning.
+	webhookQueueSize = 100
+)
+
+// Events posted to WebhookURL.
+const (
+	eventBan = "ban"
+	// eventBlock is posted for each blocked request with the webhook block
+	// action.
+	eventBlock = "block"
+)
+
+// banEvent is the JSON payload posted to WebhookURL for each new ban, and with
+// the webhook block action for each blocked request.
+type banEvent struct {
+	Event      string     `json:"event"`
+	Middleware string     `json:"middleware"`
+	IP         string     `json:"ip"`
+	Path       string     `json:"path,omitempty"` // set for blocks
+	Rule       string     `json:"rule"`
+	Reason     string     `json:"reason,omitempty"`
+	Time       time.Time  `json:"time"`
+	Duration   string     `json:"duration,omitempty"` // empty for permanent bans
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	Note       string     `json:"note,omitempty"`    // of manual bans
+	TraceID    string     `json:"traceId,omitempty"` // of the request banned or blocked
+}
+
+// parseWebhookURL validates the WebhookURL configuration.
+func parseWebhookURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+
+	return u, nil
+}
+
+// notifyBan records a ban event for clientIP, caused by the request with
+// traceID, in the event log and queues it for the webhook and syslog, if they
+// are configured.
+func (m *Fail2BanMiddleware) notifyBan(clientIP string, b ban, now time.Time, traceID string) {
+	if m.webhookURL == nil && m.events == nil && m.syslog == nil {
+		return
+	}
+
+	event := m.newBanEvent(banEvent{Event: eventBan, TraceID: traceID}, clientIP, b, now)
+	if m.events != nil {
+		m.events.add(event)
+	}
+	m.sendSyslog(event)
+	m.notify(event)
+}
+
+// recordBlock records a block event for the request in the event log and
+// queues it for syslog, if they are configured.
+func (m *Fail2BanMiddleware) recordBlock(req *http.Request, clientIP string, b ban, now time.Time) {
+	if m.events == nil && m.syslog == nil {
+		return
+	}
+
+	event := m.newBanEvent(banEvent{Event: eventBlock, Path: req.URL.Path, TraceID: m.traceID(req)}, clientIP, b, now)
+	if m.events != nil {
+		m.events.add(event)
+	}
+	m.sendSyslog(event)
+}
+
+// notifyBlock is the webhook block action: it queues a block event for the
+// request.
+func (m *Fail2BanMiddleware) notifyBlock(_ http.ResponseWriter, req *http.Request, clientIP string, b ban, now time.Time) {
+	if m.webhookURL != nil {
+		m.notify(m.newBanEvent(banEvent{Event: eventBlock, Path: req.URL.Path, TraceID: m.traceID(req)}, clientIP, b, now))
+	}
+}
+
+// newBanEvent returns event filled in for the ban b on clientIP at now.
+func (m *Fail2BanMiddleware) newBanEvent(event banEvent, clientIP string, b ban, now time.Time) banEvent {
+	event.Middleware = m.name
+	event.IP = clientIP
+	event.Rule = b.rule
+	event.Reason = b.reason
+	event.Note = b.note
+	event.Time = now.UTC()
+	if !b.expiry.IsZero() {
+		expiry := b.expiry.UTC()
+		event.ExpiresAt = &expiry
+		event.Duration = b.expiry.Sub(now).Round(time.Second).String()
+	}
+
+	return event
+}
+
+// notify queues event for the webhook, if one is configured and the
+// middleware isn't draining. It never blocks: when the queue is full the event
+// is dropped and logged.
+func (m *Fail2BanMiddleware) notify(event banEvent) {
+	if m.webhookURL == nil {
+		return
+	}
+	m.drainMu.Lock()
+	draining := m.draining
+	m.drainMu.Unlock()
+	if draining {
+		m.logger.Debug("Dropping webhook notification while shutting down", "event", event.Event, "ip", m.logIP(event.IP))
+		return
+	}
+
+	select {
+	case m.webhookQueue <- event:
+	default:
+		m.logger.Warn("Dropping webhook notification, queue is full", "event", event.Event, "ip", m.logIP(event.IP))
+	}
+}
+
+// runWebhook delivers queued ban events one at a time until m.ctx is
+// cancelled, or until the queue is empty once Shutdown starts draining.
+func (m *Fail2BanMiddleware) runWebhook() {
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-m.drained:
+			for {
+				select {
+				case event := <-m.webhookQueue:
+					m.deliverBanEvent(event)
+				default:
+					return
+				}
+			}
+		case event := <-m.webhookQueue:
+			m.deliverBanEvent(event)
+		}
+	}
+}
+
+// deliverBanEvent posts event to the webhook, retrying failed attempts. Only
+// the final failure is logged.
+func (m *Fail2BanMiddleware) deliverBanEvent(event banEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	delay := webhookRetryDelay
+	for attempt := 1; ; attempt++ {
+		err = m.postBanEvent(body)
+		if err == nil {
+			return
+		}
+		if attempt == webhookAttempts {
+			break
+		}
+
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+
+	m.logger.Error("Failed to deliver ban notification", "ip", m.logIP(event.IP), "attempts", webhookAttempts, "error", err)
+}
+
+// postBanEvent makes one delivery attempt of an encoded ban event.
+func (m *Fail2BanMiddleware) postBanEvent(body []byte) error {
+	ctx, cancel := context.WithTimeout(m.ctx, webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.webhookURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentTypeJSON)
+
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}